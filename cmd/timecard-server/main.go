@@ -0,0 +1,90 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/sabhiram/timecard/team"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	usage = `usage: timecard-server [--addr <host:port>] [--data <dir>]
+       timecard-server [--data <dir>] [--admin] --new-token <name>
+
+Collects the timecards pushed by "timecard push" and serves them back to
+"timecard pull".  Records and API tokens are kept as plain files in the data
+directory, new tokens are picked up without a restart.
+
+Tokens are issued to a developer's git user.name and only push that author's
+time, admin tokens push anyone's.
+`
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+var (
+	CLI = struct {
+		help     bool   // print application usages
+		addr     string // address to listen on
+		data     string // directory holding records and tokens
+		newToken string // issue a token for this name and exit
+		admin    bool   // issue an admin token
+	}{}
+)
+
+func main() {
+	if CLI.help {
+		log.Printf("%s\n", usage)
+		return
+	}
+
+	if len(CLI.newToken) > 0 {
+		token, err := team.NewToken(CLI.data, CLI.newToken, CLI.admin)
+		if err != nil {
+			log.Fatalf("Unable to issue token: %s\n", err.Error())
+		}
+		log.Printf("%s\n", token)
+		return
+	}
+
+	if err := os.MkdirAll(CLI.data, 0700); err != nil {
+		log.Fatalf("Unable to create data directory: %s\n", err.Error())
+	}
+	db, err := team.OpenDB(CLI.data)
+	if err != nil {
+		log.Fatalf("Unable to open records: %s\n", err.Error())
+	}
+	tokens, err := team.LoadTokens(CLI.data)
+	if err != nil {
+		log.Fatalf("Unable to read tokens: %s\n", err.Error())
+	}
+	if len(tokens) == 0 {
+		log.Printf("Warning: no API tokens, issue one with \"timecard-server --new-token <name>\".\n")
+	}
+
+	s := &team.Server{DB: db, Dir: CLI.data}
+	log.Printf("Serving %s on http://%s/\n", db.Path, CLI.addr)
+	log.Fatal(http.ListenAndServe(CLI.addr, s.Handler()))
+}
+
+func init() {
+	log.SetPrefix("")
+	log.SetFlags(0)
+	log.SetOutput(os.Stdout)
+
+	flag.BoolVar(&CLI.help, "help", false, "print help")
+	flag.BoolVar(&CLI.help, "h", false, "print help (short)")
+	flag.StringVar(&CLI.addr, "addr", "localhost:7080", "address to listen on")
+	flag.StringVar(&CLI.data, "data", ".", "directory holding records and tokens")
+	flag.StringVar(&CLI.newToken, "new-token", "", "issue an API token for this name and exit")
+	flag.BoolVar(&CLI.admin, "admin", false, "issue a token which pushes anyone's time")
+	flag.Parse()
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,504 @@
+// Package config resolves the timecard utility's settings from the `timecard`
+// section of git config files and an optional project level `.timecardrc`.
+package config
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ed25519"
+	format "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+
+	"github.com/sabhiram/timecard/git"
+	"github.com/sabhiram/timecard/timecard"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	section = "timecard"
+	rcFile  = ".timecardrc"
+)
+
+// Source identifies where a configuration value came from.  Sources are
+// listed in increasing order of precedence.
+type Source int
+
+const (
+	SourceDefault Source = iota // Built-in default value
+	SourceGlobal  Source = iota // ~/.gitconfig (or $XDG_CONFIG_HOME/git/config)
+	SourceRC      Source = iota // .timecardrc at the root of the repository
+	SourceRepo    Source = iota // .git/config of the repository
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceDefault:
+		return "default"
+	case SourceGlobal:
+		return "global"
+	case SourceRC:
+		return "timecardrc"
+	case SourceRepo:
+		return "repo"
+	}
+	return fmt.Sprintf("Source(%d)", int(s))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Key describes a single `timecard.*` configuration key.
+type Key struct {
+	Name     string
+	Default  string
+	Help     string
+	Validate func(string) error
+}
+
+func validateDuration(s string) error {
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		return fmt.Errorf("duration %q cannot be negative", s)
+	}
+	return err
+}
+
+func validateHour(s string) error {
+	h, err := strconv.Atoi(s)
+	if err == nil && (h < 0 || h > 23) {
+		return fmt.Errorf("hour %q is not within 0-23", s)
+	}
+	return err
+}
+
+func validateBool(s string) error {
+	_, err := strconv.ParseBool(s)
+	return err
+}
+
+func validateLocation(s string) error {
+	_, err := time.LoadLocation(s)
+	return err
+}
+
+func validateWeekday(s string) error {
+	_, err := timecard.ParseWeekday(s)
+	return err
+}
+
+func validateStore(s string) error {
+	if s != "file" && s != "git" {
+		return fmt.Errorf("store must be \"file\" or \"git\"")
+	}
+	return nil
+}
+
+func validateTrailer(s string) error {
+	for _, r := range s {
+		if !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return fmt.Errorf("trailer %q may only contain letters, digits and dashes", s)
+		}
+	}
+	return nil
+}
+
+func validateServer(s string) error {
+	if len(s) == 0 {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("server must be an http or https URL")
+	}
+	return nil
+}
+
+func validatePublicKey(s string) error {
+	if len(s) == 0 {
+		return nil
+	}
+	if bs, err := hex.DecodeString(s); err != nil || len(bs) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key must be %d hex encoded bytes", ed25519.PublicKeySize)
+	}
+	return nil
+}
+
+func validatePublicKeys(s string) error {
+	for _, key := range splitList(s) {
+		if err := validatePublicKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func validateFile(s string) error {
+	if err := validateNotEmpty(s); err != nil {
+		return err
+	}
+	clean := path.Clean(filepath.ToSlash(s))
+	if path.IsAbs(clean) || filepath.IsAbs(s) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("file %q must be a path inside the repository", s)
+	}
+	return nil
+}
+
+func validateAny(s string) error {
+	return nil
+}
+
+func validateNotEmpty(s string) error {
+	if len(s) == 0 {
+		return fmt.Errorf("value cannot be empty")
+	}
+	return nil
+}
+
+// Keys lists every valid configuration key.
+var Keys = []*Key{
+	{"file", ".timecard", "timecard file name, relative to the repository root", validateFile},
+	{"store", "file", "\"file\" to keep the timecard in the worktree, \"git\" for .git/timecard", validateStore},
+	{"timezone", "Local", "timezone of report periods and of entries which did not record theirs", validateLocation},
+	{"weekstart", "monday", "first day of the week in reports", validateWeekday},
+	{"maxsession", "12h", "longest plausible entry, 0 disables the check", validateDuration},
+	{"nightfrom", "2", "hour at which the overnight window opens", validateHour},
+	{"nightto", "6", "hour at which the overnight window closes", validateHour},
+	{"autocap", "false", "cap entries longer than maxsession when they are ended", validateBool},
+	{"trailer", "Time-Spent", "commit message trailer the prepare-commit-msg hook records time in, empty disables it", validateTrailer},
+	{"idle", "15m", "time without edits after which \"timecard watch\" ends the entry", validateDuration},
+	{"server", "", "URL of the team server used by push and pull", validateServer},
+	{"token", "", "API token for the team server", validateAny},
+	{"project", "", "name of the repository on the team server, defaults to the origin URL", validateAny},
+	{"signingkey", "", "file holding the ed25519 key which signs entries, see \"timecard keygen\"", validateAny},
+	{"verifykey", "", "hex encoded ed25519 public key \"timecard verify\" checks signatures with", validatePublicKey},
+	{"oldkeys", "", "comma separated hex public keys of rotated signing keys, their signatures stay valid", validatePublicKeys},
+}
+
+// rcKeys are the keys a `.timecardrc` may set.  Anyone who can commit to the
+// repository can change it, so it must not pick where the timecard is written,
+// where the token is sent to or which signatures are trusted.
+var rcKeys = map[string]bool{
+	"timezone":   true,
+	"weekstart":  true,
+	"maxsession": true,
+	"nightfrom":  true,
+	"nightto":    true,
+	"autocap":    true,
+	"trailer":    true,
+	"idle":       true,
+	"project":    true,
+}
+
+// checkSource returns an error if `k` cannot be set in `src`.
+func checkSource(k *Key, src Source) error {
+	if src == SourceRC && !rcKeys[k.Name] {
+		return fmt.Errorf("%s.%s cannot be set in %s, set it with \"git config\"", section, k.Name, rcFile)
+	}
+	return nil
+}
+
+// LookupKey returns the key named `name` (case insensitive).
+func LookupKey(name string) (*Key, error) {
+	name = strings.TrimPrefix(strings.ToLower(name), section+".")
+	for _, k := range Keys {
+		if k.Name == name {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown config key %q", name)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Value is the resolved value of a key along with where it came from.
+type Value struct {
+	Key    *Key
+	Value  string
+	Source Source
+}
+
+// Config is the merged view of all configuration sources.
+type Config struct {
+	values map[string]*Value
+	user   string // git's user.name, used to attribute uncommitted work
+}
+
+func globalPaths() []string {
+	paths := []string{}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); len(xdg) > 0 {
+		paths = append(paths, path.Join(xdg, "git", "config"))
+	} else if p, err := homedir.Expand("~/.config/git/config"); err == nil {
+		paths = append(paths, p)
+	}
+	if p, err := homedir.Expand("~/.gitconfig"); err == nil {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func readFile(fp string) (*format.Config, error) {
+	raw := format.New()
+	bs, err := ioutil.ReadFile(fp)
+	if os.IsNotExist(err) {
+		return raw, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := format.NewDecoder(bytes.NewBuffer(bs)).Decode(raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", fp, err.Error())
+	}
+	return raw, nil
+}
+
+// merge applies the `timecard` section of `raw` on top of the config.
+func (c *Config) merge(raw *format.Config, src Source) error {
+	for _, s := range raw.Sections {
+		if s.IsName("user") && len(s.Option("name")) > 0 {
+			c.user = s.Option("name")
+		}
+		if !s.IsName(section) {
+			continue
+		}
+		for _, opt := range s.Options {
+			k, err := LookupKey(opt.Key)
+			if err != nil {
+				return fmt.Errorf("%s config: %s", src, err.Error())
+			}
+			if err := checkSource(k, src); err != nil {
+				return fmt.Errorf("%s config: %s", src, err.Error())
+			}
+			if err := k.Validate(opt.Value); err != nil {
+				return fmt.Errorf("%s config: invalid %s.%s: %s", src, section, k.Name, err.Error())
+			}
+			c.values[k.Name] = &Value{Key: k, Value: opt.Value, Source: src}
+		}
+	}
+	return nil
+}
+
+// Load resolves the configuration for the repository `g` rooted at `root`.
+// Values from the repository's config override `.timecardrc` which override
+// the user's global git config.
+func Load(g *git.Git, root string) (*Config, error) {
+	c := &Config{values: map[string]*Value{}}
+	for _, k := range Keys {
+		c.values[k.Name] = &Value{Key: k, Value: k.Default, Source: SourceDefault}
+	}
+
+	for _, fp := range globalPaths() {
+		raw, err := readFile(fp)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.merge(raw, SourceGlobal); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := readFile(path.Join(root, rcFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.merge(raw, SourceRC); err != nil {
+		return nil, err
+	}
+
+	if g != nil {
+		raw, err := g.Config()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.merge(raw, SourceRepo); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Set validates and persists `key = value` to the repository's config, or to
+// the `.timecardrc` file at `root` when `src` is SourceRC.
+func Set(g *git.Git, root string, src Source, key, value string) error {
+	k, err := LookupKey(key)
+	if err != nil {
+		return err
+	}
+	if err := k.Validate(value); err != nil {
+		return fmt.Errorf("invalid %s.%s: %s", section, k.Name, err.Error())
+	}
+	if err := checkSource(k, src); err != nil {
+		return err
+	}
+
+	switch src {
+	case SourceRepo:
+		return g.SetConfigOption(section, k.Name, value)
+	case SourceRC:
+		fp := path.Join(root, rcFile)
+		raw, err := readFile(fp)
+		if err != nil {
+			return err
+		}
+		raw.SetOption(section, format.NoSubsection, k.Name, value)
+
+		buf := bytes.NewBuffer(nil)
+		if err := format.NewEncoder(buf).Encode(raw); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(fp, buf.Bytes(), 0644)
+	}
+	return fmt.Errorf("cannot write %s config", src)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Get returns the resolved value of `key`.
+func (c *Config) Get(key string) (*Value, error) {
+	k, err := LookupKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return c.values[k.Name], nil
+}
+
+// List returns the resolved value of every key.
+func (c *Config) List() []*Value {
+	vs := []*Value{}
+	for _, k := range Keys {
+		vs = append(vs, c.values[k.Name])
+	}
+	return vs
+}
+
+// The typed accessors below only see values which passed validation in Load
+// and can therefore ignore parse errors.
+
+func (c *Config) String(key string) string {
+	return c.values[key].Value
+}
+
+func (c *Config) Duration(key string) time.Duration {
+	d, _ := time.ParseDuration(c.values[key].Value)
+	return d
+}
+
+func (c *Config) Int(key string) int {
+	i, _ := strconv.Atoi(c.values[key].Value)
+	return i
+}
+
+func (c *Config) Bool(key string) bool {
+	b, _ := strconv.ParseBool(c.values[key].Value)
+	return b
+}
+
+// User returns git's configured user.name.
+func (c *Config) User() string {
+	return c.user
+}
+
+// File returns the name of the timecard file.
+func (c *Config) File() string {
+	return c.String("file")
+}
+
+// Location returns the timezone used by reports.
+func (c *Config) Location() *time.Location {
+	loc, err := time.LoadLocation(c.String("timezone"))
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// WeekStart returns the first day of the week used by reports.
+func (c *Config) WeekStart() time.Weekday {
+	d, _ := timecard.ParseWeekday(c.String("weekstart"))
+	return d
+}
+
+// SigningKey reads the private key configured to sign entries, nil if none
+// is configured.
+func (c *Config) SigningKey() (ed25519.PrivateKey, error) {
+	fp := c.String("signingkey")
+	if len(fp) == 0 {
+		return nil, nil
+	}
+	fp, err := homedir.Expand(fp)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(bs)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%s is not a timecard signing key", fp)
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// VerifyKey returns the public key to check signatures with, either the one
+// configured or the one matching the signing key.  Nil if neither is set.
+func (c *Config) VerifyKey() (ed25519.PublicKey, error) {
+	if s := c.String("verifykey"); len(s) > 0 {
+		bs, _ := hex.DecodeString(s)
+		return ed25519.PublicKey(bs), nil
+	}
+	key, err := c.SigningKey()
+	if key == nil || err != nil {
+		return nil, err
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// OldKeys returns the public keys of signing keys which were rotated out,
+// signatures made with them are still accepted.
+func (c *Config) OldKeys() []ed25519.PublicKey {
+	keys := []ed25519.PublicKey{}
+	for _, s := range splitList(c.String("oldkeys")) {
+		if bs, err := hex.DecodeString(s); err == nil && len(bs) == ed25519.PublicKeySize {
+			keys = append(keys, ed25519.PublicKey(bs))
+		}
+	}
+	return keys
+}
+
+// Rules returns the sanity rules applied to timecard entries.
+func (c *Config) Rules() timecard.Rules {
+	return timecard.Rules{
+		MaxSession: c.Duration("maxsession"),
+		NightFrom:  c.Int("nightfrom"),
+		NightTo:    c.Int("nightto"),
+		AutoCap:    c.Bool("autocap"),
+		Location:   c.Location(),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
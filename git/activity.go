@@ -0,0 +1,119 @@
+package git
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Change is a file of the worktree which differs from HEAD.
+type Change struct {
+	Path    string
+	Status  string    // Two letter status as shown by "git status --short"
+	ModTime time.Time // Zero for deleted files
+}
+
+// Changes returns the files which are staged, modified or untracked in the
+// worktree, ignored files left out.
+func (g *Git) Changes() ([]*Change, error) {
+	w, err := g.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	cs := []*Change{}
+	for p, fs := range status {
+		if fs.Staging == git.Unmodified && fs.Worktree == git.Unmodified {
+			continue
+		}
+		c := &Change{Path: p, Status: string([]byte{byte(fs.Staging), byte(fs.Worktree)})}
+		if fi, err := os.Lstat(path.Join(g.cwd, p)); err == nil {
+			c.ModTime = fi.ModTime()
+		}
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].Path < cs[j].Path
+	})
+	return cs, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ReflogEntry is a single move of HEAD, as recorded in .git/logs/HEAD.
+type ReflogEntry struct {
+	Old, New string
+	When     time.Time
+	Message  string // For example "commit: Fix typo" or "checkout: moving from a to b"
+}
+
+// Reflog returns the moves of HEAD, oldest first.  Repositories without a
+// reflog return no entries.
+func (g *Git) Reflog() ([]*ReflogEntry, error) {
+	f, err := os.Open(path.Join(g.Dir(), "logs", "HEAD"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rs := []*ReflogEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// <old> <new> <name> <<email>> <seconds> <offset>\t<message>
+		line := scanner.Text()
+		tab := strings.Index(line, "\t")
+		if tab < 0 {
+			tab = len(line)
+		}
+		fields := strings.Fields(line[:tab])
+		if len(fields) < 4 {
+			continue
+		}
+		when, ok := parseSignatureTime(fields[len(fields)-2], fields[len(fields)-1])
+		if !ok {
+			continue
+		}
+		r := &ReflogEntry{Old: fields[0], New: fields[1], When: when}
+		if tab < len(line) {
+			r.Message = line[tab+1:]
+		}
+		rs = append(rs, r)
+	}
+	return rs, scanner.Err()
+}
+
+// parseSignatureTime parses the "<seconds> <+hhmm>" of a git signature.
+func parseSignatureTime(secs, offset string) (time.Time, bool) {
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil || len(offset) != 5 {
+		return time.Time{}, false
+	}
+	hh, errH := strconv.Atoi(offset[1:3])
+	mm, errM := strconv.Atoi(offset[3:5])
+	if errH != nil || errM != nil {
+		return time.Time{}, false
+	}
+	off := hh*3600 + mm*60
+	if offset[0] == '-' {
+		off = -off
+	}
+	return time.Unix(s, 0).In(time.FixedZone("", off)), true
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,207 @@
+package git
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/diff"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// BlameLine is a line of a file along with the commit which last changed it.
+type BlameLine struct {
+	Number int // Starting at 1
+	Text   string
+	Commit *Commit
+}
+
+// Blame returns the lines of the file `file`, a path relative to the root of
+// the worktree, as of `rev` along with the commit which last changed each of
+// them.  go-git's own blame only reports the author of each line.  A line is
+// blamed on the commit which added it, lines a commit shares with one of its
+// parents are passed on to that parent, the first parent going first.
+func (g *Git) Blame(rev, file string) ([]*BlameLine, error) {
+	if len(rev) == 0 {
+		rev = "HEAD"
+	}
+	head, err := g.resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+	b := &blamer{g: g, file: file, blobs: map[plumbing.Hash]*blob{}, done: map[plumbing.Hash]*blame{}}
+	res, err := b.run(head)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, object.ErrFileNotFound
+	}
+
+	commits := map[plumbing.Hash]*Commit{}
+	lines := []*BlameLine{}
+	for i, text := range res.blob.lines {
+		c := res.origins[i]
+		if _, ok := commits[c.Hash]; !ok {
+			commits[c.Hash] = newCommit(c)
+		}
+		lines = append(lines, &BlameLine{Number: i + 1, Text: text, Commit: commits[c.Hash]})
+	}
+	return lines, nil
+}
+
+// blob is a version of the blamed file.
+type blob struct {
+	hash     plumbing.Hash
+	contents string
+	lines    []string
+}
+
+// blame is the file as of a commit along with the commit each line came from.
+type blame struct {
+	blob    *blob
+	origins []*object.Commit
+}
+
+// blamer blames the lines of a single file across the history of a commit.
+type blamer struct {
+	g     *Git
+	file  string
+	blobs map[plumbing.Hash]*blob
+	done  map[plumbing.Hash]*blame // nil for commits without the file
+}
+
+// run blames the file as of `head`.  Parents are blamed before their children
+// without recursing, histories can be long.
+func (b *blamer) run(head *object.Commit) (*blame, error) {
+	type frame struct {
+		c       *object.Commit
+		parents []*object.Commit
+		visited bool
+	}
+	stack := []*frame{{c: head}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		if _, ok := b.done[f.c.Hash]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if !f.visited {
+			f.visited = true
+			if _, err := f.c.File(b.file); err == object.ErrFileNotFound {
+				b.done[f.c.Hash] = nil // History of the file ends here
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			for _, h := range f.c.ParentHashes {
+				p, err := b.g.repo.CommitObject(h)
+				if err != nil {
+					return nil, err
+				}
+				f.parents = append(f.parents, p)
+				if _, ok := b.done[h]; !ok {
+					stack = append(stack, &frame{c: p})
+				}
+			}
+			continue
+		}
+
+		stack = stack[:len(stack)-1]
+		res, err := b.blame(f.c, f.parents)
+		if err != nil {
+			return nil, err
+		}
+		b.done[f.c.Hash] = res
+	}
+	return b.done[head.Hash], nil
+}
+
+// blame works out the origins of the lines of the file as of `c` once those
+// of its `parents` are known.
+func (b *blamer) blame(c *object.Commit, parents []*object.Commit) (*blame, error) {
+	f, err := c.File(b.file)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parents {
+		if pb := b.done[p.Hash]; pb != nil && pb.blob.hash == f.Hash {
+			return pb, nil // Unchanged
+		}
+	}
+
+	cur, err := b.blob(f)
+	if err != nil {
+		return nil, err
+	}
+	origins := make([]*object.Commit, len(cur.lines))
+	for _, p := range parents {
+		pb := b.done[p.Hash]
+		if pb == nil {
+			continue
+		}
+		line, pline := 0, 0
+		for _, h := range diff.Do(pb.blob.contents, cur.contents) {
+			n := countLines(h.Text)
+			switch h.Type {
+			case diffmatchpatch.DiffEqual:
+				for j := 0; j < n; j++ {
+					if origins[line+j] == nil {
+						origins[line+j] = pb.origins[pline+j]
+					}
+				}
+				line, pline = line+n, pline+n
+			case diffmatchpatch.DiffInsert:
+				line += n
+			case diffmatchpatch.DiffDelete:
+				pline += n
+			}
+		}
+	}
+	for i := range origins {
+		if origins[i] == nil {
+			origins[i] = c
+		}
+	}
+	return &blame{blob: cur, origins: origins}, nil
+}
+
+// blob returns the contents of `f`, versions of the file which several
+// commits share are read once.
+func (b *blamer) blob(f *object.File) (*blob, error) {
+	if bl, ok := b.blobs[f.Hash]; ok {
+		return bl, nil
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	bl := &blob{hash: f.Hash, contents: contents, lines: splitLines(contents)}
+	b.blobs[f.Hash] = bl
+	return bl, nil
+}
+
+// countLines returns the number of lines in `s`, the last one need not end
+// with a newline.
+func countLines(s string) int {
+	n := strings.Count(s, "\n")
+	if len(s) > 0 && !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	return n
+}
+
+// splitLines splits `s` into its lines, without their newlines.
+func splitLines(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+////////////////////////////////////////////////////////////////////////////////
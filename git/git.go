@@ -5,15 +5,29 @@ package git
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
+	"gopkg.in/src-d/go-billy.v3/osfs"
 	"gopkg.in/src-d/go-git.v4"
-	// "gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	format "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
 
 var (
-	ErrNotGitRepo = errors.New("not a valid git repo")
+	ErrNotGitRepo      = errors.New("not a valid git repo")
+	ErrUnknownRevision = errors.New("unknown revision")
+	ErrAmbiguousHash   = errors.New("ambiguous short hash")
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -37,6 +51,23 @@ func New(dp string) (*Git, error) {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// Dir returns the path to the repository's .git directory.  Worktrees which
+// use a ".git" file pointing elsewhere are followed.
+func (g *Git) Dir() string {
+	dp := path.Join(g.cwd, ".git")
+	if fi, err := os.Stat(dp); err == nil && !fi.IsDir() {
+		bs, err := ioutil.ReadFile(dp)
+		if err == nil && strings.HasPrefix(string(bs), "gitdir: ") {
+			target := strings.TrimSpace(strings.TrimPrefix(string(bs), "gitdir: "))
+			if !path.IsAbs(target) {
+				target = path.Join(g.cwd, target)
+			}
+			return target
+		}
+	}
+	return dp
+}
+
 // Returns the current commit has for the git repo.
 func (g *Git) GetCurrentHash() (string, error) {
 	head, err := g.repo.Head()
@@ -46,4 +77,283 @@ func (g *Git) GetCurrentHash() (string, error) {
 	return head.Hash().String(), nil
 }
 
+// RootCommit returns the hash of the commit HEAD descends from through its
+// first parents, which identifies the repository across clones.
+func (g *Git) RootCommit() (string, error) {
+	head, err := g.repo.Head()
+	if err != nil {
+		return "", err
+	}
+	c, err := g.repo.CommitObject(head.Hash())
+	if err != nil {
+		return "", err
+	}
+	for c.NumParents() > 0 {
+		if c, err = g.repo.CommitObject(c.ParentHashes[0]); err != nil {
+			return "", err
+		}
+	}
+	return c.Hash.String(), nil
+}
+
+// CurrentBranch returns the short name of the branch HEAD points at, or an
+// empty string if HEAD is detached.  HEAD is not resolved, so that the branch
+// of a repository without commits yet is known too.
+func (g *Git) CurrentBranch() (string, error) {
+	head, err := g.repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", err
+	}
+	if head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+		return "", nil
+	}
+	return head.Target().Short(), nil
+}
+
+// CommitBranches maps every commit reachable from a local branch to the name
+// of a branch containing it.  Commits on the current branch are attributed to
+// it first, the remaining branches claim whatever commits are left.
+func (g *Git) CommitBranches() (map[string]string, error) {
+	iter, err := g.repo.Branches()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := []*plumbing.Reference{}
+	if err := iter.ForEach(func(ref *plumbing.Reference) error {
+		refs = append(refs, ref)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	current, _ := g.CurrentBranch()
+	for i, ref := range refs {
+		if ref.Name().Short() == current {
+			refs[0], refs[i] = refs[i], refs[0]
+			break
+		}
+	}
+
+	branches := map[string]string{}
+	for _, ref := range refs {
+		name := ref.Name().Short()
+		commits, err := g.repo.Log(&git.LogOptions{From: ref.Hash()})
+		if err != nil {
+			return nil, err
+		}
+		err = commits.ForEach(func(c *object.Commit) error {
+			if _, ok := branches[c.Hash.String()]; !ok {
+				branches[c.Hash.String()] = name
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return branches, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Config returns the raw contents of the repository's .git/config file.
+func (g *Git) Config() (*format.Config, error) {
+	cfg, err := g.repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Raw, nil
+}
+
+// SetConfigOption sets `section.key` to `value` in the repository's config.
+func (g *Git) SetConfigOption(section, key, value string) error {
+	cfg, err := g.repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Raw.SetOption(section, format.NoSubsection, key, value)
+	return g.repo.Storer.SetConfig(cfg)
+}
+
+// RemoteURL returns the (first) URL of the remote `name`, or an empty string
+// if there is no such remote.
+func (g *Git) RemoteURL(name string) (string, error) {
+	cfg, err := g.repo.Config()
+	if err != nil {
+		return "", err
+	}
+	if r, ok := cfg.Remotes[name]; ok && len(r.URLs) > 0 {
+		return r.URLs[0], nil
+	}
+	return "", nil
+}
+
+// Worktree returns the path to the repository's working tree.
+func (g *Git) Worktree() string {
+	return g.cwd
+}
+
+// Ignored returns a function which reports whether `rel`, a path relative to
+// the root of the worktree, is ignored by the worktree's .gitignore files or
+// by .git/info/exclude.  The patterns are read when Ignored is called.
+func (g *Git) Ignored() (func(rel string, isDir bool) bool, error) {
+	ps, err := gitignore.ReadPatterns(osfs.New(g.cwd), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Patterns are matched in increasing priority, .gitignore files override
+	// the repository's exclude file.
+	excludes := []gitignore.Pattern{}
+	if bs, err := ioutil.ReadFile(path.Join(g.Dir(), "info", "exclude")); err == nil {
+		for _, line := range strings.Split(string(bs), "\n") {
+			if !strings.HasPrefix(line, "#") && len(strings.TrimSpace(line)) > 0 {
+				excludes = append(excludes, gitignore.ParsePattern(line, nil))
+			}
+		}
+	}
+
+	m := gitignore.NewMatcher(append(excludes, ps...))
+	return func(rel string, isDir bool) bool {
+		return m.Match(strings.Split(rel, "/"), isDir)
+	}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Commit is the subset of a git commit the timecard utility cares about.
+type Commit struct {
+	Hash    string
+	Parents []string
+	Author  string // Author's name
+	Email   string // Author's email
+	When    time.Time
+	Message string
+}
+
+// Subject returns the first line of the commit message.
+func (c *Commit) Subject() string {
+	return strings.SplitN(c.Message, "\n", 2)[0]
+}
+
+func newCommit(c *object.Commit) *Commit {
+	parents := []string{}
+	for _, h := range c.ParentHashes {
+		parents = append(parents, h.String())
+	}
+	return &Commit{
+		Hash:    c.Hash.String(),
+		Parents: parents,
+		Author:  c.Author.Name,
+		Email:   c.Author.Email,
+		When:    c.Author.When,
+		Message: c.Message,
+	}
+}
+
+// peel resolves `h` to a commit, following annotated tags.
+func (g *Git) peel(h plumbing.Hash) (*object.Commit, error) {
+	if tag, err := g.repo.TagObject(h); err == nil {
+		return tag.Commit()
+	}
+	return g.repo.CommitObject(h)
+}
+
+// resolveBase resolves a ref name or a (possibly abbreviated) commit hash.
+func (g *Git) resolveBase(name string) (*object.Commit, error) {
+	for _, prefix := range []string{"", "refs/", "refs/tags/", "refs/heads/", "refs/remotes/"} {
+		ref, err := storer.ResolveReference(g.repo.Storer, plumbing.ReferenceName(prefix+name))
+		if err == nil && ref != nil {
+			return g.peel(ref.Hash())
+		}
+	}
+
+	if len(name) < 4 || len(name) > 40 || strings.Trim(strings.ToLower(name), "0123456789abcdef") != "" {
+		return nil, ErrUnknownRevision
+	}
+	if len(name) == 40 {
+		return g.peel(plumbing.NewHash(name))
+	}
+
+	iter, err := g.repo.CommitObjects()
+	if err != nil {
+		return nil, err
+	}
+	var found *object.Commit
+	err = iter.ForEach(func(c *object.Commit) error {
+		if strings.HasPrefix(c.Hash.String(), strings.ToLower(name)) {
+			if found != nil && found.Hash != c.Hash {
+				return ErrAmbiguousHash
+			}
+			found = c
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrUnknownRevision
+	}
+	return found, nil
+}
+
+// resolve resolves a revision such as "HEAD~2", "v1.0^2" or "1a2b3c".
+func (g *Git) resolve(rev string) (*object.Commit, error) {
+	i := strings.IndexAny(rev, "~^")
+	if i < 0 {
+		i = len(rev)
+	}
+	c, err := g.resolveBase(rev[:i])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", rev, err.Error())
+	}
+
+	for rest := rev[i:]; len(rest) > 0; {
+		op, n, j := rest[0], 1, 1
+		for j < len(rest) && rest[j] >= '0' && rest[j] <= '9' {
+			j++
+		}
+		if j > 1 {
+			n, _ = strconv.Atoi(rest[1:j])
+		}
+		rest = rest[j:]
+
+		switch {
+		case op == '^' && n == 0:
+			continue
+		case op == '^':
+			if n > c.NumParents() {
+				return nil, fmt.Errorf("%s: %s", rev, ErrUnknownRevision.Error())
+			}
+			if c, err = g.repo.CommitObject(c.ParentHashes[n-1]); err != nil {
+				return nil, err
+			}
+		case op == '~':
+			for ; n > 0; n-- {
+				if c.NumParents() == 0 {
+					return nil, fmt.Errorf("%s: %s", rev, ErrUnknownRevision.Error())
+				}
+				if c, err = g.repo.CommitObject(c.ParentHashes[0]); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%s: %s", rev, ErrUnknownRevision.Error())
+		}
+	}
+	return c, nil
+}
+
+// Commit looks up the commit for the revision `rev`, this can be a ref name,
+// a (possibly abbreviated) hash with optional "~n" and "^n" suffixes.
+func (g *Git) Commit(rev string) (*Commit, error) {
+	c, err := g.resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+	return newCommit(c), nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
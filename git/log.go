@@ -0,0 +1,208 @@
+package git
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Log returns the commits selected by `spec`, newest first.  A revision such
+// as "HEAD" or "v1.0~2" selects it and its ancestors, a range "a..b" selects
+// the commits reachable from b but not from a, either side defaulting to
+// HEAD.  With `firstParent` only the first parent of merges is followed, which
+// walks the mainline of a branch and skips the commits merged into it.
+func (g *Git) Log(spec string, firstParent bool) ([]*Commit, error) {
+	if len(spec) == 0 {
+		spec = "HEAD"
+	}
+	from, to := "", spec
+	if i := strings.Index(spec, ".."); i >= 0 {
+		from, to = spec[:i], spec[i+2:]
+		if len(from) == 0 {
+			from = "HEAD"
+		}
+		if len(to) == 0 {
+			to = "HEAD"
+		}
+	}
+
+	if len(from) == 0 {
+		return g.revList([]string{to}, nil, firstParent)
+	}
+	return g.revList([]string{to}, []string{from}, firstParent)
+}
+
+// revList returns the commits reachable from any of `include` but from none
+// of `exclude`, newest first for each of `include` in turn.
+func (g *Git) revList(include, exclude []string, firstParent bool) ([]*Commit, error) {
+	excluded := map[plumbing.Hash]bool{}
+	for _, rev := range exclude {
+		c, err := g.resolve(rev)
+		if err != nil {
+			return nil, err
+		}
+		if err := g.walk(c, false, func(c *object.Commit) bool {
+			if excluded[c.Hash] {
+				return false
+			}
+			excluded[c.Hash] = true
+			return true
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[plumbing.Hash]bool{}
+	commits := []*Commit{}
+	for _, rev := range include {
+		head, err := g.resolve(rev)
+		if err != nil {
+			return nil, err
+		}
+		if err := g.walk(head, firstParent, func(c *object.Commit) bool {
+			if excluded[c.Hash] || seen[c.Hash] {
+				return false
+			}
+			seen[c.Hash] = true
+			commits = append(commits, newCommit(c))
+			return true
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return commits, nil
+}
+
+// walk visits `c` and its ancestors newest first (by author date), following
+// only first parents if `firstParent` is set.  The parents of commits for
+// which `visit` returns false are not walked.
+func (g *Git) walk(c *object.Commit, firstParent bool, visit func(*object.Commit) bool) error {
+	pending := []*object.Commit{c}
+	for len(pending) > 0 {
+		newest := 0
+		for i, p := range pending {
+			if p.Author.When.After(pending[newest].Author.When) {
+				newest = i
+			}
+		}
+		c := pending[newest]
+		pending = append(pending[:newest], pending[newest+1:]...)
+		if !visit(c) {
+			continue
+		}
+
+		parents := c.ParentHashes
+		if firstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+		for _, h := range parents {
+			p, err := g.repo.CommitObject(h)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, p)
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Merge is a merge commit on the mainline of a branch along with the commits
+// it brought into the branch.
+type Merge struct {
+	*Commit
+	Introduced []*Commit // Not including the merge itself
+}
+
+// Merges returns the merge commits on the first-parent line of the commits
+// selected by `spec` (see Log), newest first, each with the commits it
+// introduced: those reachable from its other parents but not from its first.
+// The mainline is walked oldest first, keeping the set of commits reachable
+// so far, so that each merge only walks the history it introduced.
+func (g *Git) Merges(spec string) ([]*Merge, error) {
+	mainline, err := g.Log(spec, true)
+	if err != nil || len(mainline) == 0 {
+		return nil, err
+	}
+
+	// Everything below the selected mainline was reachable already.
+	reached := map[plumbing.Hash]bool{}
+	if oldest := mainline[len(mainline)-1]; len(oldest.Parents) > 0 {
+		c, err := g.resolve(oldest.Parents[0])
+		if err != nil {
+			return nil, err
+		}
+		if err := g.walk(c, false, func(c *object.Commit) bool {
+			if reached[c.Hash] {
+				return false
+			}
+			reached[c.Hash] = true
+			return true
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	merges := []*Merge{}
+	for i := len(mainline) - 1; i >= 0; i-- {
+		c := mainline[i]
+		if len(c.Parents) > 1 {
+			introduced := []*Commit{}
+			for _, rev := range c.Parents[1:] {
+				p, err := g.resolve(rev)
+				if err != nil {
+					return nil, err
+				}
+				if err := g.walk(p, false, func(c *object.Commit) bool {
+					if reached[c.Hash] {
+						return false
+					}
+					reached[c.Hash] = true
+					introduced = append(introduced, newCommit(c))
+					return true
+				}); err != nil {
+					return nil, err
+				}
+			}
+			merges = append(merges, &Merge{Commit: c, Introduced: introduced})
+		}
+		reached[plumbing.NewHash(c.Hash)] = true
+	}
+
+	for i, j := 0, len(merges)-1; i < j; i, j = i+1, j-1 {
+		merges[i], merges[j] = merges[j], merges[i]
+	}
+	return merges, nil
+}
+
+// pullRequestPatterns match the references to pull (or merge) requests which
+// hosting services put in the messages of the merges they make.
+var pullRequestPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^Merge pull request (#\d+)`),         // GitHub
+	regexp.MustCompile(`\(pull request (#\d+)\)`),            // Bitbucket
+	regexp.MustCompile(`(?m)^See merge request \S*?(!\d+)$`), // GitLab
+	regexp.MustCompile(`^Merged PR (\d+):`),                  // Azure DevOps
+}
+
+// PullRequest returns the pull request the commit merged, such as "#42" or
+// "!42" for GitLab merge requests, if its message names one.
+func (c *Commit) PullRequest() string {
+	for _, re := range pullRequestPatterns {
+		if m := re.FindStringSubmatch(c.Message); m != nil {
+			if m[1][0] >= '0' && m[1][0] <= '9' {
+				return "#" + m[1]
+			}
+			return m[1]
+		}
+	}
+	return ""
+}
+
+////////////////////////////////////////////////////////////////////////////////
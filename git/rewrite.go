@@ -0,0 +1,206 @@
+package git
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	fdiff "gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Exists returns true if the commit `hash` is still in the object store, even
+// if it is no longer reachable from any ref.
+func (g *Git) Exists(hash string) bool {
+	if len(hash) != 40 {
+		return false
+	}
+	_, err := g.repo.CommitObject(plumbing.NewHash(hash))
+	return err == nil
+}
+
+// Reachable returns every commit reachable from any ref (branches, tags,
+// remotes and HEAD) keyed by hash.
+func (g *Git) Reachable() (map[string]*Commit, error) {
+	refs, err := g.repo.References()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []plumbing.Hash{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			pending = append(pending, ref.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if head, err := g.repo.Head(); err == nil {
+		pending = append(pending, head.Hash())
+	}
+
+	commits := map[string]*Commit{}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := commits[h.String()]; ok {
+			continue
+		}
+
+		c, err := g.peel(h)
+		if err != nil {
+			continue // refs to trees or blobs
+		}
+		commits[c.Hash.String()] = newCommit(c)
+		pending = append(pending, c.ParentHashes...)
+	}
+	return commits, nil
+}
+
+// PatchID returns a stable identifier for the change introduced by commit
+// `hash` relative to its first parent.  Like `git patch-id` it ignores line
+// numbers and whitespace, so a commit and its cherry-picked (or rebased) copy
+// share the same ID.
+func (g *Git) PatchID(hash string) (string, error) {
+	c, err := g.resolve(hash)
+	if err != nil {
+		return "", err
+	}
+	to, err := c.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	var from *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := g.repo.CommitObject(c.ParentHashes[0])
+		if err != nil {
+			return "", err
+		}
+		if from, err = parent.Tree(); err != nil {
+			return "", err
+		}
+	}
+
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return "", err
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return "", err
+	}
+
+	stripFn := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+
+	files := patch.FilePatches()
+	sort.Slice(files, func(i, j int) bool {
+		return filePatchPath(files[i]) < filePatchPath(files[j])
+	})
+
+	h := sha1.New()
+	for _, fp := range files {
+		io.WriteString(h, "file "+filePatchPath(fp)+"\n")
+		for _, chunk := range fp.Chunks() {
+			prefix := ""
+			switch chunk.Type() {
+			case fdiff.Add:
+				prefix = "+"
+			case fdiff.Delete:
+				prefix = "-"
+			default:
+				continue
+			}
+			for _, line := range strings.Split(chunk.Content(), "\n") {
+				if line = stripFn(line); len(line) > 0 {
+					io.WriteString(h, prefix+line+"\n")
+				}
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func filePatchPath(fp fdiff.FilePatch) string {
+	from, to := fp.Files()
+	if to != nil {
+		return to.Path()
+	}
+	if from != nil {
+		return from.Path()
+	}
+	return ""
+}
+
+// Ways in which FindCopy can match a commit to its copy.
+const (
+	MatchPatchID = "patch-id"
+	MatchMessage = "message"
+)
+
+// FindCopy looks for a commit in `reachable` which carries the same change as
+// the (usually unreachable) commit `hash`, as left behind by a cherry-pick or a
+// rebase.  Candidates must share the original's author and author date, which
+// both operations preserve.  A candidate with the same patch id is preferred,
+// failing that one with the same commit message (the change itself may have
+// been altered while resolving conflicts).  Returns the hash of the copy and
+// how it was matched, or empty strings if there is no match.
+func (g *Git) FindCopy(hash string, reachable map[string]*Commit) (string, string, error) {
+	orig, err := g.Commit(hash)
+	if err != nil {
+		return "", "", err
+	}
+
+	candidates := []*Commit{}
+	for _, c := range reachable {
+		if c.Hash != orig.Hash && c.Email == orig.Email && c.When.Equal(orig.When) {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", "", nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Hash < candidates[j].Hash
+	})
+
+	id, err := g.PatchID(orig.Hash)
+	if err != nil {
+		return "", "", err
+	}
+	for _, c := range candidates {
+		cid, err := g.PatchID(c.Hash)
+		if err != nil {
+			return "", "", err
+		}
+		if cid == id {
+			return c.Hash, MatchPatchID, nil
+		}
+	}
+
+	for _, c := range candidates {
+		if strings.TrimSpace(c.Message) == strings.TrimSpace(orig.Message) {
+			return c.Hash, MatchMessage, nil
+		}
+	}
+	return "", "", nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -3,28 +3,77 @@ package main
 ////////////////////////////////////////////////////////////////////////////////
 
 import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/sabhiram/timecard/config"
 	"github.com/sabhiram/timecard/git"
+	"github.com/sabhiram/timecard/registry"
+	"github.com/sabhiram/timecard/serve"
+	"github.com/sabhiram/timecard/team"
 	"github.com/sabhiram/timecard/timecard"
+	"github.com/sabhiram/timecard/watch"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
 
 const (
-	timecardFile = ".timecard"
-	version      = "0.0.1"
-	usage        = `usage: timecard [--version] [--help] <command> [<args>]
+	version = "0.0.1"
+	usage   = `usage: timecard [--version] [--help] <command> [<args>]
 
 Valid Timecard commands include:
     init        Create an empty timecard or re-initialize an existing one
     start       Start or re-start the timecard for the current commit
-    checkpoint  Create a checkpoint within a given interval
+    checkpoint  Record a checkpoint within the current entry
     end         End a timestamp with a given tag (usually a commit hash)
+    watch       Start and end the timecard as files in the worktree are edited
+    infer       Propose a start for a forgotten "timecard start" from the worktree
+    timesheet   Print a daily, weekly or monthly timesheet
+    review      Interactively accept, cap or split implausible entries
+    config      Get, set or list timecard.* configuration values
+    list        List timecard entries along with their index
+    log         Show the commit log with the time spent on each commit
+    blame       Show the time spent on the commit which last changed each line
+    stats       Show distributions of commit durations, sessions and working hours
+    add         Add a completed entry for a given commit
+    amend       Change the times, hash or note of an existing entry
+    rm          Remove entries from the timecard
+    undo        Revert the last N changes made to the timecard
+    redo        Re-apply the last N undone changes
+    history     List the changes recorded in the undo journal
+    report      Report time per repository, day and author, or for a release
+    post-rewrite Remap entries after amend or rebase (run by the post-rewrite hook)
+    prepare-commit-msg Add the time spent to the commit message (run by the hook)
+    commit-msg  Abort commits whose message is only the time trailer (run by the hook)
+    import      Rebuild entries from the time recorded in commit trailers
+    gc          Relocate or archive entries whose commits are gone
+    serve       Serve a JSON API and dashboard on localhost
+    metrics     Print metrics or write them for a textfile collector
+    push        Upload time per commit to the team server
+    pull        Download and report the team's time from the team server
+    keygen      Create a key to sign timecard entries with
+    verify      Check that entries were not edited behind timecard's back
 `
 )
 
@@ -42,18 +91,138 @@ var (
 
 type cmdFn func(args []string) error
 
-func initFunc(args []string) error {
+// tcCmdFn is a command which changes the (already opened and locked) timecard.
+type tcCmdFn func(tc *timecard.Timecard, cfg *config.Config, args []string) error
+
+// openRepo opens the git repository in the current working directory along
+// with its timecard configuration.
+func openRepo() (*git.Git, *config.Config, error) {
 	g, err := git.New(CLI.cwd)
 	if err != nil {
 		log.Fatalf("Error: Could not find a valid git repository at %s. Did you \"git init\"?\n", CLI.cwd)
 	}
+	cfg, err := config.Load(g, CLI.cwd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, cfg, nil
+}
+
+// storePath returns the path of the timecard file configured for the
+// repository `g` at `dir`.
+func storePath(dir string, g *git.Git, cfg *config.Config) string {
+	if cfg.String("store") == "git" {
+		return timecard.NewGitStore(g).Path
+	}
+	return path.Join(dir, cfg.File())
+}
+
+// openStore returns the store configured for the repository `g` at `dir`.
+// Its lock file lives in the .git directory, out of the worktree.
+func openStore(dir string, g *git.Git, cfg *config.Config) timecard.Store {
+	fs := timecard.NewFileStore(storePath(dir, g, cfg))
+	fs.LockPath = path.Join(g.Dir(), "timecard", "lock")
+	return fs
+}
+
+// openTimecardAt loads the timecard for the git repository at `dir`.
+func openTimecardAt(dir string) (*timecard.Timecard, *config.Config, error) {
+	g, err := git.New(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := config.Load(g, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return openTimecardWith(dir, g, cfg)
+}
+
+// openTimecardWith loads the timecard for the repository `g` at `dir` whose
+// configuration `cfg` was already loaded.
+func openTimecardWith(dir string, g *git.Git, cfg *config.Config) (*timecard.Timecard, *config.Config, error) {
+	tc, err := timecard.Open(g, openStore(dir, g, cfg))
+	if err != nil {
+		return nil, nil, err
+	}
+	tc.Rules = cfg.Rules()
+	if tc.Signer, err = cfg.SigningKey(); err != nil {
+		return nil, nil, err
+	}
+	tc.OldKeys = cfg.OldKeys()
+	return tc, cfg, nil
+}
+
+// openTimecard loads the timecard for the git repository in the current
+// working directory.
+func openTimecard() (*timecard.Timecard, *config.Config, error) {
+	g, cfg, err := openRepo()
+	if err != nil {
+		return nil, nil, err
+	}
+	return openTimecardWith(CLI.cwd, g, cfg)
+}
+
+// openJournal opens the undo journal kept for the timecard `tc`.
+func openJournal(tc *timecard.Timecard) (*timecard.Journal, error) {
+	return timecard.OpenJournal(path.Join(tc.Repo().Dir(), "timecard"))
+}
+
+// runJournaled locks the timecard of the repository at `dir` for the duration
+// of `fn` and records the change it makes in the undo journal as `cmd`.
+func runJournaled(dir, cmd string, fn func(*timecard.Timecard, *config.Config) error) error {
+	tc, cfg, err := openTimecardAt(dir)
+	if err != nil {
+		return err
+	}
+	if err := tc.Lock(); err != nil {
+		return err
+	}
+	defer tc.Unlock()
+
+	before := tc.Snapshot()
+	fnErr := fn(tc, cfg)
+
+	j, err := openJournal(tc)
+	if err != nil {
+		return err
+	}
+	if err := j.Record(cmd, before, tc.Snapshot()); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// journaled wraps a command which changes the timecard.  The timecard is
+// locked for the duration of the command and the change is recorded in the
+// undo journal.
+func journaled(name string, fn tcCmdFn) cmdFn {
+	return func(args []string) error {
+		if _, _, err := openRepo(); err != nil {
+			return err
+		}
+		cmd := strings.Join(append([]string{name}, args...), " ")
+		return runJournaled(CLI.cwd, cmd, func(tc *timecard.Timecard, cfg *config.Config) error {
+			return fn(tc, cfg, args)
+		})
+	}
+}
+
+func initFunc(args []string) error {
+	g, cfg, err := openRepo()
+	if err != nil {
+		return err
+	}
 
-	tcfp := path.Join(CLI.cwd, timecardFile)
+	tcfp := storePath(CLI.cwd, g, cfg)
 	if _, err := os.Stat(tcfp); os.IsNotExist(err) {
 		// Create a default timecard for this project
-		if _, err := timecard.Init(g, tcfp); err != nil {
+		if _, err := timecard.Create(g, openStore(CLI.cwd, g, cfg)); err != nil {
 			return err
 		}
+		if err := registry.Add(CLI.cwd); err != nil {
+			log.Printf("Warning: unable to register %s for cross-repo reports: %s\n", CLI.cwd, err.Error())
+		}
 		log.Printf("Initialized new timecard for %s in %s.", "user", tcfp)
 		return nil
 	}
@@ -63,46 +232,1796 @@ func initFunc(args []string) error {
 	return nil
 }
 
-func startFunc(args []string) error {
-	g, err := git.New(CLI.cwd)
+// atFlag registers --at on `fs`, the time a command records instead of now.
+func atFlag(fs *flag.FlagSet) *string {
+	return fs.String("at", "", "record this time instead of now (e.g. \"15m ago\", \"yesterday 17:30\")")
+}
+
+// setClock stops the timecard's clock at the time given by --at, if any.
+func setClock(tc *timecard.Timecard, cfg *config.Config, at string) error {
+	if len(at) == 0 {
+		return nil
+	}
+	now := tc.Now()
+	t, err := timecard.ParseTime(at, now, cfg.Location())
 	if err != nil {
-		log.Fatalf("Error: Could not find a valid git repository at %s. Did you \"git init\"?\n", CLI.cwd)
+		return err
+	}
+	if t.After(now) {
+		return fmt.Errorf("--at %s is in the future", t.Format(time.RFC3339))
+	}
+	tc.Clock = timecard.FixedClock(t)
+	return nil
+}
+
+// clockedFunc returns a command which takes --at and then calls `fn`.
+func clockedFunc(name string, fn func(tc *timecard.Timecard) error) tcCmdFn {
+	return func(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		at := atFlag(fs)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if err := setClock(tc, cfg, *at); err != nil {
+			return err
+		}
+		return fn(tc)
+	}
+}
+
+var (
+	startFunc = clockedFunc("start", func(tc *timecard.Timecard) error {
+		// Repositories initialized before the registry existed join it here.
+		if err := registry.Add(CLI.cwd); err != nil {
+			log.Printf("Warning: unable to register %s for cross-repo reports: %s\n", CLI.cwd, err.Error())
+		}
+		return tc.Start()
+	})
+	checkpointFunc = clockedFunc("checkpoint", (*timecard.Timecard).Checkpoint)
+	endFunc        = clockedFunc("end", (*timecard.Timecard).End)
+)
+
+// watchFunc starts the timecard when files in the worktree change and ends it,
+// at the time of the last change, once they stopped changing for a while.
+func watchFunc(args []string) error {
+	var idle string
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.StringVar(&idle, "idle", "", "end the entry after this long without changes (timecard.idle)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	g, cfg, err := openRepo()
+	if err != nil {
+		return err
+	}
+	d := cfg.Duration("idle")
+	if len(idle) > 0 {
+		if d, err = time.ParseDuration(idle); err != nil {
+			return err
+		}
+	}
+	if d <= 0 {
+		return errors.New("the idle time must be positive")
+	}
+
+	// pending returns the timecard's open entry, if any.
+	pending := func(tc *timecard.Timecard) *timecard.Entry {
+		if n := len(tc.Entries); n > 0 && tc.Entries[n-1].StateName() == "pending" {
+			return tc.Entries[n-1]
+		}
+		return nil
+	}
+
+	// Writes to the timecard itself are not edits.  The watcher reports
+	// slash separated paths relative to the worktree, timecard.file may be
+	// spelled "./.timecard" or be absolute.
+	file := filepath.ToSlash(filepath.Clean(cfg.File()))
+	root, errRoot := filepath.Abs(g.Worktree())
+	fp, errFile := filepath.Abs(storePath(CLI.cwd, g, cfg))
+	if errRoot == nil && errFile == nil {
+		if rel, err := filepath.Rel(root, fp); err == nil {
+			file = filepath.ToSlash(rel)
+		}
+	}
+	w := &watch.Watcher{
+		Root: g.Worktree(),
+		Idle: d,
+		Ignored: func() (watch.IgnoreFn, error) {
+			ignored, err := g.Ignored()
+			if err != nil {
+				return nil, err
+			}
+			return func(rel string, isDir bool) bool {
+				return path.Clean(rel) == file || ignored(rel, isDir)
+			}, nil
+		},
+		Active: func(at time.Time) error {
+			return runJournaled(CLI.cwd, "watch start", func(tc *timecard.Timecard, cfg *config.Config) error {
+				if pending(tc) != nil {
+					return nil
+				}
+				log.Printf("%s Editing, starting the timecard.\n", at.Format("15:04:05"))
+				tc.Clock = timecard.FixedClock(at)
+				return tc.Start()
+			})
+		},
+		Inactive: func(last time.Time) error {
+			return runJournaled(CLI.cwd, "watch end", func(tc *timecard.Timecard, cfg *config.Config) error {
+				e := pending(tc)
+				if e == nil {
+					return nil
+				}
+				if last.Before(e.Start) {
+					last = e.Start // Re-started by hand since the last change
+				}
+				log.Printf("%s Idle since %s, ending the timecard.\n",
+					time.Now().Format("15:04:05"), last.Format("15:04:05"))
+				tc.Clock = timecard.FixedClock(last)
+				return tc.End()
+			})
+		},
+	}
+
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		close(stop)
+	}()
+
+	log.Printf("Watching %s, idle after %s. Press Ctrl-C to stop.\n", g.Worktree(), d)
+	return w.Run(stop)
+}
+
+// inferFunc proposes a start for the current change set from the worktree's
+// changes and the reflog, and starts (or backdates) the open entry if the
+// proposal is accepted.
+func inferFunc(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+	var yes bool
+	fs := flag.NewFlagSet("infer", flag.ContinueOnError)
+	fs.BoolVar(&yes, "yes", false, "accept the proposed start without asking")
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
 
-	tcfp := path.Join(CLI.cwd, timecardFile)
-	tc, err := timecard.Load(g, tcfp)
+	inf, err := tc.Infer(cfg.Duration("idle"))
 	if err != nil {
 		return err
 	}
+
+	const layout = "2006-01-02 15:04:05"
+	loc := cfg.Location()
+	saved := 0
+	for _, c := range inf.Changes {
+		if c.ModTime.After(inf.Since) {
+			saved++
+		}
+	}
+	if inf.Since.IsZero() {
+		log.Printf("%d changed files.\n", len(inf.Changes))
+	} else {
+		log.Printf("%d changed files, %d saved since %s.\n", len(inf.Changes), saved, inf.Since.In(loc).Format(layout))
+	}
+	if inf.Start.IsZero() {
+		log.Printf("Nothing to infer a start from: %s.\n", inf.Reason)
+		return nil
+	}
+	log.Printf("Proposed start: %s (%s).\n", inf.Start.In(loc).Format(layout), inf.Reason)
+	if inf.Current != nil && !inf.Start.Before(inf.Current.Start) {
+		log.Printf("The open entry started at %s already, nothing to do.\n", inf.Current.Start.In(loc).Format(layout))
+		return nil
+	}
+
+	if !yes {
+		action := "Start the timecard"
+		if inf.Current != nil {
+			action = "Move the open entry's start"
+		}
+		fmt.Printf("%s at %s? [y/N] ", action, inf.Start.In(loc).Format(layout))
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && len(answer) == 0 {
+			return err
+		}
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return nil
+		}
+	}
+	tc.Clock = timecard.FixedClock(inf.Start)
 	return tc.Start()
 }
 
-func checkpointFunc(args []string) error {
-	log.Printf("checkpoint: %#v\n", args)
+// periodFlags are the flags shared by commands which report on a calendar
+// period.
+type periodFlags struct {
+	day, week, month    bool
+	tz, weekStart, date string
+}
+
+func newPeriodFlags(fs *flag.FlagSet) *periodFlags {
+	pf := &periodFlags{}
+	fs.BoolVar(&pf.day, "day", false, "report on a single day")
+	fs.BoolVar(&pf.week, "week", false, "report on a week (default)")
+	fs.BoolVar(&pf.month, "month", false, "report on a calendar month")
+	fs.StringVar(&pf.tz, "tz", "", "timezone of the period and of entries which did not record theirs (timecard.timezone)")
+	fs.StringVar(&pf.weekStart, "week-start", "", "first day of the week (timecard.weekstart)")
+	fs.StringVar(&pf.date, "date", "", "any day (YYYY-MM-DD) inside the period, defaults to today")
+	return pf
+}
+
+// options returns the timesheet options selected by the flags, falling back
+// on the configuration in `cfg`.
+func (pf *periodFlags) options(cfg *config.Config) (timecard.TimesheetOptions, error) {
+	opts := timecard.TimesheetOptions{Period: timecard.PeriodWeek, User: cfg.User()}
+	switch {
+	case pf.day:
+		opts.Period = timecard.PeriodDay
+	case pf.month:
+		opts.Period = timecard.PeriodMonth
+	}
+
+	var err error
+	opts.Location, opts.WeekStart = cfg.Location(), cfg.WeekStart()
+	if len(pf.tz) > 0 {
+		if opts.Location, err = time.LoadLocation(pf.tz); err != nil {
+			return opts, err
+		}
+	}
+	if len(pf.weekStart) > 0 {
+		if opts.WeekStart, err = timecard.ParseWeekday(pf.weekStart); err != nil {
+			return opts, err
+		}
+	}
+	if len(pf.date) > 0 {
+		if opts.At, err = time.ParseInLocation("2006-01-02", pf.date, opts.Location); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+func timesheetFunc(args []string) error {
+	var by string
+	fs := flag.NewFlagSet("timesheet", flag.ContinueOnError)
+	fs.StringVar(&by, "by", "commit", "group rows by \"commit\", \"branch\" or \"author\"")
+	pf := newPeriodFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tc, cfg, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	opts, err := pf.options(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch by {
+	case "commit":
+		opts.GroupBy = timecard.GroupByCommit
+	case "branch":
+		opts.GroupBy = timecard.GroupByBranch
+	case "author":
+		opts.GroupBy = timecard.GroupByAuthor
+	default:
+		return fmt.Errorf("cannot group timesheet by %q", by)
+	}
+
+	ts, err := tc.Timesheet(opts)
+	if err != nil {
+		return err
+	}
+
+	cellFn := func(d time.Duration) string {
+		if d == 0 {
+			return "-"
+		}
+		return timecard.FormatDuration(d)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "\t")
+	for _, d := range ts.Days {
+		fmt.Fprintf(w, "%s\t", d.Format("Mon 01/02"))
+	}
+	fmt.Fprint(w, "Total\t\n")
+	for _, row := range ts.Rows {
+		label := row.Label
+		if row.Flagged {
+			label += " *"
+		}
+		fmt.Fprintf(w, "%s\t", label)
+		for _, d := range row.Days {
+			fmt.Fprintf(w, "%s\t", cellFn(d))
+		}
+		fmt.Fprintf(w, "%s\t\n", cellFn(row.Total))
+	}
+	fmt.Fprint(w, "Total\t")
+	for _, d := range ts.DayTotals() {
+		fmt.Fprintf(w, "%s\t", cellFn(d))
+	}
+	fmt.Fprintf(w, "%s\t\n", cellFn(ts.Total()))
+	return w.Flush()
+}
+
+func reviewFunc(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+	now := tc.Now()
+	in := bufio.NewReader(os.Stdin)
+	promptFn := func(msg string) (string, error) {
+		fmt.Print(msg)
+		line, err := in.ReadString('\n')
+		return strings.TrimSpace(line), err
+	}
+
+	flagged := tc.Flagged(now)
+	if len(flagged) == 0 {
+		log.Printf("No flagged timecard entries.\n")
+		return nil
+	}
+
+	// Splitting inserts entries, walk backwards so earlier indices stay valid.
+	for i := len(flagged) - 1; i >= 0; i-- {
+		idx := flagged[i]
+		e := tc.Entries[idx]
+		vs := []string{}
+		for _, v := range tc.Rules.Check(e, now) {
+			vs = append(vs, v.String())
+		}
+		log.Printf("\nEntry %d: %s - %s (%s), %s\n", idx,
+			e.StartTime().Format(time.RFC1123), e.EndTime(now).Format(time.RFC1123),
+			timecard.FormatDuration(e.Duration(now)), strings.Join(vs, ", "))
+
+		answer, err := promptFn("[a]ccept, [c]ap, [s]plit, [n]ext or [q]uit? ")
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(answer) {
+		case "a", "accept":
+			e.Accepted = true
+		case "c", "cap":
+			tc.Rules.Cap(e, now)
+		case "s", "split":
+			const layout = "2006-01-02 15:04"
+			endStr, err := promptFn("End of first part (" + layout + "): ")
+			if err != nil {
+				return err
+			}
+			end, err := timecard.ParseTime(endStr, now, cfg.Location())
+			if err != nil {
+				return err
+			}
+			startStr, err := promptFn("Start of second part (" + layout + "): ")
+			if err != nil {
+				return err
+			}
+			start, err := timecard.ParseTime(startStr, now, cfg.Location())
+			if err != nil {
+				return err
+			}
+			if err := tc.Split(idx, end, start); err != nil {
+				log.Printf("Warning: %s, leaving it as is.\n", err.Error())
+			}
+		case "q", "quit":
+			return tc.Flush()
+		}
+	}
+	return tc.Flush()
+}
+
+func configFunc(args []string) error {
+	var rc bool
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.BoolVar(&rc, "rc", false, "write to .timecardrc instead of .git/config")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	g, cfg, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	args = fs.Args()
+	if len(args) == 0 {
+		return errors.New("usage: timecard config [--rc] get <key> | set <key> <value> | list")
+	}
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "get":
+		if len(args) != 1 {
+			return errors.New("usage: timecard config get <key>")
+		}
+		v, err := cfg.Get(args[0])
+		if err != nil {
+			return err
+		}
+		log.Printf("%s\n", v.Value)
+	case "set":
+		if len(args) != 2 {
+			return errors.New("usage: timecard config [--rc] set <key> <value>")
+		}
+		src := config.SourceRepo
+		if rc {
+			src = config.SourceRC
+		}
+		return config.Set(g, CLI.cwd, src, args[0], args[1])
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, v := range cfg.List() {
+			fmt.Fprintf(w, "timecard.%s\t%s\t(%s)\t%s\n", v.Key.Name, v.Value, v.Source, v.Key.Help)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown config command %q", cmd)
+	}
 	return nil
 }
 
-func endFunc(args []string) error {
-	g, err := git.New(CLI.cwd)
+func listFunc(args []string) error {
+	tc, cfg, err := openTimecard()
 	if err != nil {
-		log.Fatalf("Error: Could not find a valid git repository at %s. Did you \"git init\"?\n", CLI.cwd)
+		return err
 	}
 
-	tcfp := path.Join(CLI.cwd, timecardFile)
-	tc, err := timecard.Load(g, tcfp)
+	const layout = "2006-01-02 15:04"
+	now := tc.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, e := range tc.Entries {
+		// Show the times in the timezone they were recorded in.
+		loc := e.Location(cfg.Location())
+		end := "-"
+		if !e.End.IsZero() {
+			end = e.End.In(loc).Format(layout)
+		}
+		hash := e.Hash
+		if len(hash) == 0 {
+			hash = "(uncommitted)"
+		}
+		fmt.Fprintf(w, "@%d\t%s\t%s\t%s\t%s\t%s\n", i,
+			e.Start.In(loc).Format(layout), end,
+			timecard.FormatDuration(e.Duration(now)), hash, e.Note)
+	}
+	return w.Flush()
+}
+
+// pager returns where to write output meant to be read on a terminal: the
+// input of the user's pager ($TIMECARD_PAGER, $PAGER or less) when stdout is
+// a terminal, stdout otherwise.  The returned function waits for the pager.
+func pager(disabled bool) (io.Writer, func()) {
+	fi, err := os.Stdout.Stat()
+	if disabled || err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return os.Stdout, func() {}
+	}
+
+	cmd := os.Getenv("TIMECARD_PAGER")
+	if len(cmd) == 0 {
+		cmd = os.Getenv("PAGER")
+	}
+	if len(cmd) == 0 {
+		cmd = "less"
+	}
+	if cmd == "cat" {
+		return os.Stdout, func() {}
+	}
+
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdout, c.Stderr = os.Stdout, os.Stderr
+	c.Env = os.Environ()
+	if len(os.Getenv("LESS")) == 0 {
+		// Quit if the output fits on one screen, keep colors and the screen.
+		c.Env = append(c.Env, "LESS=FRX")
+	}
+	in, err := c.StdinPipe()
+	if err != nil {
+		return os.Stdout, func() {}
+	}
+	if err := c.Start(); err != nil {
+		return os.Stdout, func() {}
+	}
+	return in, func() {
+		in.Close()
+		c.Wait()
+	}
+}
+
+// logFunc prints the commits of HEAD (or of a revision range) along with the
+// time tracked on each of them.
+func logFunc(args []string) error {
+	var firstParent, noTimeOnly, noPager bool
+	var max int
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	fs.BoolVar(&firstParent, "first-parent", false, "follow only the first parent of merge commits")
+	fs.BoolVar(&noTimeOnly, "no-time-only", false, "only show commits without tracked time")
+	fs.BoolVar(&noPager, "no-pager", false, "do not pipe the output through a pager")
+	fs.IntVar(&max, "n", 0, "show at most this many commits")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("usage: timecard log [--first-parent] [--no-time-only] [-n <count>] [--no-pager] [<revision>|<from>..<to>]")
+	}
+
+	tc, _, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	commits, err := tc.Repo().Log(fs.Arg(0), firstParent)
+	if err != nil {
+		return err
+	}
+	times := tc.CommitTimes(tc.Now())
+
+	out, wait := pager(noPager)
+	defer wait()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	shown, tracked := 0, 0
+	var total time.Duration
+	for _, c := range commits {
+		if max > 0 && shown >= max {
+			break
+		}
+		d, ok := times[c.Hash]
+		if noTimeOnly && ok {
+			continue
+		}
+		spent := "-"
+		if ok {
+			spent = timecard.FormatDuration(d)
+			tracked++
+			total += d
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Hash[:7], spent,
+			c.When.Format("2006-01-02 15:04"), c.Author, c.Subject())
+		shown++
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if !noTimeOnly {
+		fmt.Fprintf(out, "\n%d commits, %d with tracked time, %s in total.\n", shown, tracked, timecard.FormatDuration(total))
+	}
+	return nil
+}
+
+// blameFunc prints the lines of a file along with the commit which last
+// changed each of them and the time tracked on that commit.
+func blameFunc(args []string) error {
+	var noPager bool
+	fs := flag.NewFlagSet("blame", flag.ContinueOnError)
+	fs.BoolVar(&noPager, "no-pager", false, "do not pipe the output through a pager")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rev, file := "HEAD", fs.Arg(0)
+	switch fs.NArg() {
+	case 1:
+	case 2:
+		rev, file = fs.Arg(0), fs.Arg(1)
+	default:
+		return errors.New("usage: timecard blame [--no-pager] [<revision>] <file>")
+	}
+
+	tc, _, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	if path.IsAbs(file) {
+		rel, err := filepath.Rel(CLI.cwd, file)
+		if err != nil {
+			return err
+		}
+		file = filepath.ToSlash(rel)
+	}
+	lines, err := tc.Repo().Blame(rev, path.Clean(file))
 	if err != nil {
+		return fmt.Errorf("%s: %s", file, err.Error())
+	}
+	times := tc.CommitTimes(tc.Now())
+
+	out, wait := pager(noPager)
+	defer wait()
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	commits, tracked := map[string]bool{}, 0
+	for _, l := range lines {
+		spent := "-"
+		if d, ok := times[l.Commit.Hash]; ok {
+			spent = timecard.FormatDuration(d)
+		}
+		if !commits[l.Commit.Hash] {
+			commits[l.Commit.Hash] = true
+			if spent != "-" {
+				tracked++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d)\t%s\n", l.Commit.Hash[:7], spent,
+			l.Commit.When.Format("2006-01-02"), l.Number, l.Text)
+	}
+	if err := w.Flush(); err != nil {
 		return err
 	}
-	return tc.End()
+	fmt.Fprintf(out, "\n%d lines from %d commits, %d with tracked time.\n", len(lines), len(commits), tracked)
+	return nil
+}
+
+// statsJSON is the --json form of timecard.Stats, durations in seconds like
+// in the local API.
+type statsJSON struct {
+	Commits           int           `json:"commits"`
+	Total             int64         `json:"total"`
+	Median            int64         `json:"median"`
+	P90               int64         `json:"p90"`
+	Longest           int64         `json:"longest"`
+	Histogram         []*bucketJSON `json:"histogram"`
+	Sessions          int           `json:"sessions"`
+	SessionsPerCommit float64       `json:"sessions_per_commit"`
+	Fragmented        int           `json:"fragmented"`
+	MedianSession     int64         `json:"median_session"`
+	Hours             []int64       `json:"hours"`    // Per hour of the day
+	Weekdays          []int64       `json:"weekdays"` // Sunday first
+	LongestStreak     *streakJSON   `json:"longest_streak"`
+	CurrentStreak     *streakJSON   `json:"current_streak"`
+}
+
+type bucketJSON struct {
+	Min     int64 `json:"min"`
+	Max     int64 `json:"max,omitempty"` // Open ended if missing
+	Commits int   `json:"commits"`
+}
+
+type streakJSON struct {
+	First string `json:"first,omitempty"` // YYYY-MM-DD
+	Last  string `json:"last,omitempty"`
+	Days  int    `json:"days"`
+}
+
+func newStatsJSON(s *timecard.Stats) *statsJSON {
+	secs := func(d time.Duration) int64 { return int64(d / time.Second) }
+	streak := func(st timecard.Streak) *streakJSON {
+		if st.Days == 0 {
+			return &streakJSON{}
+		}
+		return &streakJSON{First: st.First.Format("2006-01-02"), Last: st.Last.Format("2006-01-02"), Days: st.Days}
+	}
+	sj := &statsJSON{
+		Commits:           s.Commits,
+		Total:             secs(s.Total),
+		Median:            secs(s.Median),
+		P90:               secs(s.P90),
+		Longest:           secs(s.Longest),
+		Sessions:          s.Sessions,
+		SessionsPerCommit: s.SessionsPerCommit,
+		Fragmented:        s.Fragmented,
+		MedianSession:     secs(s.MedianSession),
+		LongestStreak:     streak(s.LongestStreak),
+		CurrentStreak:     streak(s.CurrentStreak),
+	}
+	for _, b := range s.Histogram {
+		sj.Histogram = append(sj.Histogram, &bucketJSON{Min: secs(b.Min), Max: secs(b.Max), Commits: b.Commits})
+	}
+	for _, d := range s.Hours {
+		sj.Hours = append(sj.Hours, secs(d))
+	}
+	for _, d := range s.Weekdays {
+		sj.Weekdays = append(sj.Weekdays, secs(d))
+	}
+	return sj
+}
+
+// bar renders `n` out of `max` as a bar of at most 30 characters.
+func bar(n, max int64) string {
+	if max <= 0 {
+		return ""
+	}
+	return strings.Repeat("#", int((n*30+max-1)/max))
+}
+
+// statsFunc prints the distribution of commit durations, how work is split
+// into sessions and when it happens.
+func statsFunc(args []string) error {
+	var asJSON bool
+	var tz string
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.BoolVar(&asJSON, "json", false, "print the statistics as JSON")
+	fs.StringVar(&tz, "tz", "", "timezone of entries which did not record theirs (timecard.timezone)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("usage: timecard stats [--json] [--tz <zone>]")
+	}
+
+	tc, cfg, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	opts := timecard.StatsOptions{Location: cfg.Location()}
+	if len(tz) > 0 {
+		if opts.Location, err = time.LoadLocation(tz); err != nil {
+			return err
+		}
+	}
+	s := tc.Stats(opts)
+
+	if asJSON {
+		bs, err := json.MarshalIndent(newStatsJSON(s), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", bs)
+		return nil
+	}
+
+	fd := timecard.FormatDuration
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Commits:\t%d with tracked time, %s in total\n", s.Commits, fd(s.Total))
+	fmt.Fprintf(w, "Per commit:\tmedian %s, p90 %s, longest %s\n", fd(s.Median), fd(s.P90), fd(s.Longest))
+	fmt.Fprintf(w, "Sessions:\t%d, %.1f per commit, median %s, %d commits took several\n",
+		s.Sessions, s.SessionsPerCommit, fd(s.MedianSession), s.Fragmented)
+	fmt.Fprintf(w, "Streaks:\tlongest %d days", s.LongestStreak.Days)
+	if s.LongestStreak.Days > 0 {
+		fmt.Fprintf(w, " (%s to %s)", s.LongestStreak.First.Format("2006-01-02"), s.LongestStreak.Last.Format("2006-01-02"))
+	}
+	fmt.Fprintf(w, ", current %d days\n", s.CurrentStreak.Days)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nCommit durations:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	most := 0
+	for _, b := range s.Histogram {
+		if b.Commits > most {
+			most = b.Commits
+		}
+	}
+	for _, b := range s.Histogram {
+		label := "< " + fd(b.Max)
+		switch {
+		case b.Max == 0:
+			label = ">= " + fd(b.Min)
+		case b.Min > 0:
+			label = fd(b.Min) + " - " + fd(b.Max)
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%s\n", label, b.Commits, bar(int64(b.Commits), int64(most)))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nTime of day:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var peak time.Duration
+	for _, d := range s.Hours {
+		if d > peak {
+			peak = d
+		}
+	}
+	for h, d := range s.Hours {
+		if d > 0 {
+			fmt.Fprintf(w, "  %02d:00\t%s\t%s\n", h, fd(d), bar(int64(d), int64(peak)))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nWeekdays:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	peak = 0
+	for _, d := range s.Weekdays {
+		if d > peak {
+			peak = d
+		}
+	}
+	for i := 0; i < 7; i++ {
+		day := (cfg.WeekStart() + time.Weekday(i)) % 7
+		d := s.Weekdays[day]
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", day.String()[:3], fd(d), bar(int64(d), int64(peak)))
+	}
+	return w.Flush()
+}
+
+// entryFlags are the flags shared by the commands which edit entries.
+type entryFlags struct {
+	fs                             *flag.FlagSet
+	start, end, duration, note, at string
+	set                            map[string]bool
+}
+
+func newEntryFlags(name string) *entryFlags {
+	ef := &entryFlags{fs: flag.NewFlagSet(name, flag.ContinueOnError), set: map[string]bool{}}
+	ef.fs.StringVar(&ef.start, "start", "", "start time of the entry")
+	ef.fs.StringVar(&ef.end, "end", "", "end time of the entry")
+	ef.fs.StringVar(&ef.duration, "duration", "", "duration of the entry (e.g. 1h30m)")
+	ef.fs.StringVar(&ef.note, "note", "", "note to attach to the entry")
+	ef.fs.StringVar(&ef.at, "at", "", "time relative times such as \"2h ago\" are taken from")
+	return ef
+}
+
+func (ef *entryFlags) parse(args []string) error {
+	if err := ef.fs.Parse(args); err != nil {
+		return err
+	}
+	ef.fs.Visit(func(f *flag.Flag) {
+		ef.set[f.Name] = true
+	})
+	return nil
+}
+
+// apply updates the times and note of `e` from the flags which were given.
+// Unless a start time is given, a duration keeps the entry's end fixed.
+func (ef *entryFlags) apply(tc *timecard.Timecard, e *timecard.Entry, loc *time.Location) error {
+	now := tc.Now()
+	if ef.set["start"] {
+		t, err := timecard.ParseTime(ef.start, now, loc)
+		if err != nil {
+			return err
+		}
+		e.Start = t
+	}
+	if ef.set["end"] {
+		t, err := timecard.ParseTime(ef.end, now, loc)
+		if err != nil {
+			return err
+		}
+		e.End = t
+	}
+	if ef.set["duration"] {
+		d, err := time.ParseDuration(ef.duration)
+		if err != nil {
+			return err
+		}
+		if ef.set["start"] || (!ef.set["end"] && e.End.IsZero()) {
+			e.End = e.Start.Add(d)
+		} else {
+			e.Start = e.End.Add(-d)
+		}
+	}
+	if ef.set["note"] {
+		e.Note = ef.note
+	}
+	return nil
+}
+
+func addFunc(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+	ef := newEntryFlags("add")
+	if err := ef.parse(args); err != nil {
+		return err
+	}
+	if err := setClock(tc, cfg, ef.at); err != nil {
+		return err
+	}
+	if ef.fs.NArg() != 1 {
+		return errors.New("usage: timecard add [--start <time>] [--end <time>] [--duration <duration>] [--note <note>] <commit>")
+	}
+	if !ef.set["start"] && !ef.set["duration"] {
+		return errors.New("one of --start or --duration is required")
+	}
+
+	c, err := tc.Repo().Commit(ef.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Entries end when the commit was made unless told otherwise.
+	e := &timecard.Entry{Hash: c.Hash, End: c.When}
+	if err := ef.apply(tc, e, cfg.Location()); err != nil {
+		return err
+	}
+	idx, err := tc.Add(e)
+	if err != nil {
+		return err
+	}
+	log.Printf("Added entry @%d for %s (%s).\n", idx, c.Hash, timecard.FormatDuration(e.Duration(tc.Now())))
+	return nil
+}
+
+func amendFunc(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+	var hash string
+	ef := newEntryFlags("amend")
+	ef.fs.StringVar(&hash, "hash", "", "commit the entry should be attributed to")
+	if err := ef.parse(args); err != nil {
+		return err
+	}
+	if err := setClock(tc, cfg, ef.at); err != nil {
+		return err
+	}
+	if ef.fs.NArg() != 1 {
+		return errors.New("usage: timecard amend [--start <time>] [--end <time>] [--duration <duration>] [--hash <commit>] [--note <note>] <@index|hash>")
+	}
+
+	idx, err := tc.Find(ef.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	e := tc.Entries[idx]
+	if err := ef.apply(tc, e, cfg.Location()); err != nil {
+		return err
+	}
+	if ef.set["hash"] {
+		c, err := tc.Repo().Commit(hash)
+		if err != nil {
+			return err
+		}
+		e.Hash = c.Hash
+	}
+	return tc.Update(idx)
+}
+
+func rmFunc(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: timecard rm <@index|hash>...")
+	}
+
+	idxs := []int{}
+	for _, sel := range args {
+		idx, err := tc.Find(sel)
+		if err != nil {
+			return fmt.Errorf("%s: %s", sel, err.Error())
+		}
+		idxs = append(idxs, idx)
+	}
+
+	// Remove from the back so earlier indices stay valid.
+	sort.Sort(sort.Reverse(sort.IntSlice(idxs)))
+	for i, idx := range idxs {
+		if i > 0 && idxs[i-1] == idx {
+			continue
+		}
+		if err := tc.Remove(idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoRedoFunc returns the implementation of the undo or redo command.
+func undoRedoFunc(name string, undo bool) cmdFn {
+	return func(args []string) error {
+		var force bool
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		fs.BoolVar(&force, "force", false, "ignore changes made outside of timecard")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		n := 1
+		if fs.NArg() > 0 {
+			var err error
+			if n, err = strconv.Atoi(fs.Arg(0)); err != nil || n < 1 {
+				return fmt.Errorf("usage: timecard %s [--force] [<count>]", name)
+			}
+		}
+
+		tc, _, err := openTimecard()
+		if err != nil {
+			return err
+		}
+		if err := tc.Lock(); err != nil {
+			return err
+		}
+		defer tc.Unlock()
+
+		j, err := openJournal(tc)
+		if err != nil {
+			return err
+		}
+
+		fn, verb := j.Redo, "Redid"
+		if undo {
+			fn, verb = j.Undo, "Undid"
+		}
+		ops, err := fn(tc, n, force)
+		for _, op := range ops {
+			log.Printf("%s %d: %s\n", verb, op.ID, op.Command)
+		}
+		return err
+	}
+}
+
+func historyFunc(args []string) error {
+	var n int
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.IntVar(&n, "n", 20, "number of operations to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tc, _, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	j, err := openJournal(tc)
+	if err != nil {
+		return err
+	}
+
+	ops := j.Ops
+	if n > 0 && len(ops) > n {
+		ops = ops[len(ops)-n:]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := ops[i]
+		status := ""
+		if op.Undone {
+			status = "(undone)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", op.ID, op.Time.Format("2006-01-02 15:04:05"),
+			op.Summary(), op.Command, status)
+	}
+	return w.Flush()
+}
+
+func reportFunc(args []string) error {
+	var all, prune bool
+	var title, by string
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	fs.BoolVar(&all, "all", false, "report across every repository timecard was initialized in")
+	fs.BoolVar(&prune, "prune", false, "with --all, forget repositories which no longer have a timecard")
+	fs.StringVar(&by, "by", "", "group by \"merge\": the time of each pull request merged into the branch")
+	fs.StringVar(&title, "title", "", "with a revision range, the heading of the changelog section")
+	pf := newPeriodFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if prune && !all {
+		return errors.New("--prune only applies to --all")
+	}
+	period := false
+	fs.Visit(func(f *flag.Flag) {
+		period = period || (f.Name != "title" && f.Name != "by")
+	})
+	switch {
+	case fs.NArg() > 1:
+		return errors.New("usage: timecard report [--all [--prune]] [<period flags>] | [--title <title>] <revision>|<from>..<to> | --by merge [<revision>|<from>..<to>]")
+	case by == "merge":
+		if period || len(title) > 0 {
+			return errors.New("--by merge cannot be combined with --all, --title or the period flags")
+		}
+		return mergeReport(fs.Arg(0))
+	case len(by) > 0:
+		return fmt.Errorf("unknown grouping %q, only \"merge\" is supported", by)
+	case fs.NArg() == 1:
+		if period {
+			return errors.New("a revision range cannot be combined with --all or the period flags")
+		}
+		return changelogReport(fs.Arg(0), title)
+	}
+
+	repos := []string{CLI.cwd}
+	if all {
+		var err error
+		if repos, err = registry.List(); err != nil {
+			return err
+		}
+	}
+
+	var total time.Duration
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "REPO\tDAY\tAUTHOR\tTIME\n")
+	for _, repo := range repos {
+		tc, cfg, err := openTimecardAt(repo)
+		if err != nil {
+			if prune {
+				log.Printf("Forgetting %s: %s\n", repo, err.Error())
+				if err := registry.Remove(repo); err != nil {
+					return err
+				}
+			} else {
+				log.Printf("Warning: skipping %s: %s\n", repo, err.Error())
+			}
+			continue
+		}
+
+		opts, err := pf.options(cfg)
+		if err != nil {
+			return err
+		}
+		opts.GroupBy = timecard.GroupByAuthor
+		ts, err := tc.Timesheet(opts)
+		if err != nil {
+			return err
+		}
+		if ts.Total() == 0 {
+			continue
+		}
+
+		name := path.Base(repo)
+		for i, day := range ts.Days {
+			for _, row := range ts.Rows {
+				if row.Days[i] > 0 {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, day.Format("Mon 01/02"), row.Label,
+						timecard.FormatDuration(row.Days[i]))
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s total\t\t\t%s\n", name, timecard.FormatDuration(ts.Total()))
+		total += ts.Total()
+	}
+	fmt.Fprintf(w, "TOTAL\t\t\t%s\n", timecard.FormatDuration(total))
+	return w.Flush()
+}
+
+// changelogReport prints a Markdown changelog section for the commits selected
+// by `spec`, a revision or a range such as "v1.3..v1.4", listing each commit
+// with its time and the total for the release.
+func changelogReport(spec, title string) error {
+	tc, _, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	commits, err := tc.Repo().Log(spec, false)
+	if err != nil {
+		return err
+	}
+	times := tc.CommitTimes(tc.Now())
+
+	if len(title) == 0 {
+		title = spec
+		if i := strings.Index(spec, ".."); i >= 0 {
+			title = spec[i+2:]
+		}
+		if len(title) == 0 || title == "HEAD" {
+			title = "Unreleased"
+		}
+	}
+
+	var total time.Duration
+	untracked := 0
+	fmt.Printf("## %s\n\n", title)
+	for _, c := range commits {
+		spent := "untracked"
+		if d, ok := times[c.Hash]; ok {
+			spent = timecard.FormatDuration(d)
+			total += d
+		} else {
+			untracked++
+		}
+		fmt.Printf("- %s (`%s`, %s, %s)\n", c.Subject(), c.Hash[:7], c.Author, spent)
+	}
+	if len(commits) > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("**Total: %s** over %d commits", timecard.FormatDuration(total), len(commits))
+	if untracked > 0 {
+		fmt.Printf(" (%d untracked)", untracked)
+	}
+	fmt.Println(".")
+	return nil
+}
+
+// mergeReport prints the time of every merge on the first-parent line of the
+// commits selected by `spec`: that of the commits it brought in and of the
+// merge itself.  Commits made on the mainline directly are summed separately.
+func mergeReport(spec string) error {
+	tc, _, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	merges, err := tc.Repo().Merges(spec)
+	if err != nil {
+		return err
+	}
+	mainline, err := tc.Repo().Log(spec, true)
+	if err != nil {
+		return err
+	}
+	times := tc.CommitTimes(tc.Now())
+
+	var total, direct time.Duration
+	directCommits := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "PR\tMERGE\tCOMMITS\tTIME\tSUBJECT\n")
+	for _, m := range merges {
+		d := times[m.Hash]
+		for _, c := range m.Introduced {
+			d += times[c.Hash]
+		}
+		pr := m.PullRequest()
+		if len(pr) == 0 {
+			pr = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", pr, m.Hash[:7], len(m.Introduced),
+			timecard.FormatDuration(d), m.Subject())
+		total += d
+	}
+	for _, c := range mainline {
+		if len(c.Parents) < 2 {
+			direct += times[c.Hash]
+			directCommits++
+		}
+	}
+	if directCommits > 0 {
+		fmt.Fprintf(w, "direct\t\t%d\t%s\t(commits made on the mainline)\n", directCommits, timecard.FormatDuration(direct))
+		total += direct
+	}
+	fmt.Fprintf(w, "TOTAL\t\t\t%s\t\n", timecard.FormatDuration(total))
+	return w.Flush()
+}
+
+// postRewriteFunc handles git's post-rewrite hook, which passes the kind of
+// rewrite as its argument and "<old-hash> <new-hash> [<extra>]" lines on stdin.
+func postRewriteFunc(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+	var noPatchID bool
+	fs := flag.NewFlagSet("post-rewrite", flag.ContinueOnError)
+	fs.BoolVar(&noPatchID, "no-patch-id", false, "do not look for cherry-picked copies of unreachable commits")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rewrites := map[string]string{}
+	squashed := map[string]int{}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		rewrites[fields[0]] = fields[1]
+		squashed[fields[1]]++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	n, err := tc.Remap(rewrites)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Remapped %d timecard entries.\n", n)
+	}
+
+	now := tc.Now()
+	for hash, count := range squashed {
+		if count < 2 {
+			continue
+		}
+		var total time.Duration
+		for _, e := range tc.Entries {
+			if e.Hash == hash {
+				total += e.Duration(now)
+			}
+		}
+		log.Printf("Squashed %d commits into %s (%s).\n", count, hash, timecard.FormatDuration(total))
+	}
+
+	if noPatchID {
+		return nil
+	}
+	copies, err := tc.RelocateCopies()
+	for from, to := range copies {
+		log.Printf("Relocated entries of %s to its copy %s.\n", from, to)
+	}
+	return err
+}
+
+// prepareCommitMsgFunc adds the time of the open entry (plus, when amending,
+// the time already spent on the amended commit) to the commit message as a
+// trailer.  Run by the prepare-commit-msg hook with the hook's arguments: the
+// message file, the message's source and the commit it came from.
+func prepareCommitMsgFunc(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: timecard prepare-commit-msg <file> [<source> [<commit>]]")
+	}
+	tc, cfg, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	token := cfg.String("trailer")
+	if len(token) == 0 {
+		return nil
+	}
+
+	bs, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	source, commit := "", ""
+	if len(args) > 1 {
+		source = args[1]
+	}
+	if len(args) > 2 {
+		commit = args[2]
+	}
+	set, amend := timecard.TrailerHook(string(bs), token, source, commit)
+	if !set {
+		return nil
+	}
+
+	amended := ""
+	if amend {
+		if amended, err = tc.Repo().GetCurrentHash(); err != nil {
+			return err
+		}
+	}
+	d := tc.CommitTime(amended, tc.Now())
+	if d < time.Minute {
+		return nil
+	}
+	msg := timecard.SetTrailer(string(bs), token, timecard.FormatDuration(d))
+	return ioutil.WriteFile(args[0], []byte(msg), 0644)
+}
+
+// commitMsgFunc fails when the commit message holds nothing but the trailer
+// added by prepare-commit-msg, so that leaving the message empty still aborts
+// the commit.  Run by the commit-msg hook with the message file.
+func commitMsgFunc(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: timecard commit-msg <file>")
+	}
+	_, cfg, err := openRepo()
+	if err != nil {
+		return err
+	}
+	token := cfg.String("trailer")
+	if len(token) == 0 {
+		return nil
+	}
+	bs, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if timecard.OnlyTrailer(string(bs), token) {
+		return errors.New("aborting commit due to empty commit message")
+	}
+	return nil
+}
+
+// importFunc adds entries for the commits which record their time in a
+// trailer, rebuilding a timecard from history.
+func importFunc(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+	var fromTrailers bool
+	var token string
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.BoolVar(&fromTrailers, "from-trailers", false, "import the time recorded in commit message trailers")
+	fs.StringVar(&token, "trailer", "", "trailer to read (timecard.trailer)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !fromTrailers {
+		return errors.New("usage: timecard import --from-trailers [--trailer <token>]")
+	}
+	if len(token) == 0 {
+		token = cfg.String("trailer")
+	}
+	if len(token) == 0 {
+		return errors.New("no trailer to import, set timecard.trailer or pass --trailer")
+	}
+
+	added, skipped, err := tc.ImportTrailers(token)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %d entries from %s trailers, skipped %d commits which already had entries.\n", added, token, skipped)
+	return nil
+}
+
+// gcFunc compacts the timecard's event log and checks every entry's commit
+// against the repository.  Entries of commits which were rewritten behind
+// timecard's back are relocated to the rewritten copy, the remaining dangling
+// entries are reported and optionally moved to an archive file next to the
+// undo journal.
+func gcFunc(tc *timecard.Timecard, cfg *config.Config, args []string) error {
+	var dryRun, archive bool
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	fs.BoolVar(&dryRun, "dry-run", false, "only report dangling entries")
+	fs.BoolVar(&archive, "archive", false, "move entries whose commits are gone to the archive")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if n, ok := tc.Count(); !ok {
+		return fmt.Errorf("timecard header expects %d entries, found %d: check them with \"timecard verify\" "+
+			"and accept them with \"timecard verify --reseal\"", n, len(tc.Entries))
+	}
+	if !dryRun {
+		if err := tc.Compact(); err != nil {
+			return err
+		}
+	}
+
+	ds, err := tc.Dangling()
+	if err != nil {
+		return err
+	}
+	if len(ds) == 0 {
+		log.Printf("All timecard entries refer to reachable commits.\n")
+		return nil
+	}
+
+	now := tc.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ENTRY\tHASH\tDURATION\tSTATUS\tCOPY\n")
+	rewrites := map[string]string{}
+	gone, unreachable := []int{}, 0
+	for _, d := range ds {
+		cp := "-"
+		switch d.Status {
+		case timecard.DanglingRelocatable:
+			cp = fmt.Sprintf("%s (%s)", d.Copy, d.Match)
+			rewrites[d.Entry.Hash] = d.Copy
+		case timecard.DanglingUnreachable:
+			unreachable++
+		case timecard.DanglingMissing:
+			gone = append(gone, d.Index)
+		}
+		fmt.Fprintf(w, "@%d\t%s\t%s\t%s\t%s\n", d.Index, d.Entry.Hash,
+			timecard.FormatDuration(d.Entry.Duration(now)), d.Status, cp)
+	}
+	w.Flush()
+
+	if dryRun {
+		return nil
+	}
+
+	n, err := tc.Remap(rewrites)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Relocated %d timecard entries.\n", n)
+	}
+
+	if unreachable > 0 {
+		// The commits are still in the object store, they may be brought
+		// back by a branch or a reflog and are kept until git prunes them.
+		log.Printf("%d entries refer to commits which are unreachable but not gone, they are kept.\n", unreachable)
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	if !archive {
+		log.Printf("%d entries refer to commits which are gone, run \"timecard gc --archive\" to archive them.\n", len(gone))
+		return nil
+	}
+	fp := path.Join(tc.Repo().Dir(), "timecard", "archive")
+	if err := os.MkdirAll(path.Dir(fp), 0755); err != nil {
+		return err
+	}
+	if err := tc.Archive(gone, fp); err != nil {
+		return err
+	}
+	log.Printf("Archived %d timecard entries to %s.\n", len(gone), fp)
+	return nil
+}
+
+// newServer returns a server for the current repository, or for every
+// registered repository if `all` is set.
+func newServer(all bool) (*serve.Server, error) {
+	repos := []string{CLI.cwd}
+	if all {
+		var err error
+		if repos, err = registry.List(); err != nil {
+			return nil, err
+		}
+	} else if _, _, err := openRepo(); err != nil {
+		return nil, err
+	}
+	return serve.New(repos, openTimecardAt, runJournaled), nil
+}
+
+// serveFunc serves the timecard of the current repository (or of every
+// registered repository) over HTTP until interrupted.
+func serveFunc(args []string) error {
+	var all bool
+	var addr string
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.BoolVar(&all, "all", false, "serve every repository timecard was initialized in")
+	var token string
+	fs.StringVar(&addr, "addr", "localhost:7070", "address to listen on")
+	fs.StringVar(&token, "token", "", "token POST requests must send, random unless given")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := newServer(all)
+	if err != nil {
+		return err
+	}
+	if len(token) > 0 {
+		s.Token = token
+	}
+	if len(s.Token) == 0 {
+		return errors.New("unable to generate an API token, pass one with --token")
+	}
+	log.Printf("Serving %d repositories on http://%s/\n", len(s.Repos), addr)
+	log.Printf("POST requests must send the header %s: %s\n", serve.TokenHeader, s.Token)
+	return s.ListenAndServe(addr)
+}
+
+// metricsFunc prints the metrics served at /metrics, or writes them to a file
+// for node_exporter's textfile collector.
+func metricsFunc(args []string) error {
+	var all bool
+	var textfile string
+	fs := flag.NewFlagSet("metrics", flag.ContinueOnError)
+	fs.BoolVar(&all, "all", false, "report every repository timecard was initialized in")
+	fs.StringVar(&textfile, "textfile", "", "atomically write the metrics to this file (Prometheus text format)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := newServer(all)
+	if err != nil {
+		return err
+	}
+	if len(textfile) > 0 {
+		return s.WriteTextfile(textfile)
+	}
+	return s.WriteMetrics(os.Stdout, true)
+}
+
+// teamFlags selects the team server, falling back on the configuration.
+type teamFlags struct {
+	server, token string
+}
+
+func newTeamFlags(fs *flag.FlagSet) *teamFlags {
+	tf := &teamFlags{}
+	fs.StringVar(&tf.server, "server", "", "URL of the team server (timecard.server)")
+	fs.StringVar(&tf.token, "token", "", "API token for the team server (timecard.token)")
+	return tf
+}
+
+func (tf *teamFlags) client(cfg *config.Config) (*team.Client, error) {
+	server, token := cfg.String("server"), cfg.String("token")
+	if len(tf.server) > 0 {
+		server = tf.server
+	}
+	if len(tf.token) > 0 {
+		token = tf.token
+	}
+	return team.NewClient(server, token)
+}
+
+// teamProject returns the name of the repository on the team server.
+func teamProject(tc *timecard.Timecard, cfg *config.Config) (string, error) {
+	if p := cfg.String("project"); len(p) > 0 {
+		return p, nil
+	}
+	remote, err := tc.Repo().RemoteURL("origin")
+	if err != nil {
+		return "", err
+	}
+	if len(remote) == 0 {
+		return "", errors.New("no origin remote, set timecard.project to name the repository")
+	}
+	return team.ProjectName(remote), nil
+}
+
+func pushFunc(args []string) error {
+	var dryRun bool
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	fs.BoolVar(&dryRun, "dry-run", false, "print the records instead of uploading them")
+	tf := newTeamFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tc, cfg, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	project, err := teamProject(tc, cfg)
+	if err != nil {
+		return err
+	}
+	rs, err := team.Records(tc, project, cfg.User(), tc.Now())
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, r := range rs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Project, r.Commit[:7], r.Author,
+				timecard.FormatDuration(time.Duration(r.Seconds)*time.Second))
+		}
+		return w.Flush()
+	}
+
+	c, err := tf.client(cfg)
+	if err != nil {
+		return err
+	}
+	res, err := c.Push(rs)
+	if err != nil {
+		return err
+	}
+	log.Printf("Pushed %d commits of %s (%d new, %d updated).\n", len(rs), project, res.Added, res.Updated)
+	return nil
+}
+
+func pullFunc(args []string) error {
+	var all bool
+	var by string
+	fs := flag.NewFlagSet("pull", flag.ContinueOnError)
+	fs.BoolVar(&all, "all", false, "report on every project on the server")
+	fs.StringVar(&by, "by", "author", "group the report by author, commit or project")
+	tf := newTeamFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tc, cfg, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	project := ""
+	if !all {
+		if project, err = teamProject(tc, cfg); err != nil {
+			return err
+		}
+	}
+	c, err := tf.client(cfg)
+	if err != nil {
+		return err
+	}
+	rs, err := c.Records(project)
+	if err != nil {
+		return err
+	}
+
+	// Keep the team's records around for offline use.
+	bs, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Join(tc.Repo().Dir(), "timecard"), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(tc.Repo().Dir(), "timecard", "team.json"), bs, 0644); err != nil {
+		return err
+	}
+
+	rows, err := team.Report(rs, by)
+	if err != nil {
+		return err
+	}
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tTIME\tCOMMITS\tAUTHORS\n", strings.ToUpper(by))
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", row.Label,
+			timecard.FormatDuration(time.Duration(row.Seconds)*time.Second), row.Commits, row.Authors)
+		total += row.Seconds
+	}
+	fmt.Fprintf(w, "TOTAL\t%s\t\t\n", timecard.FormatDuration(time.Duration(total)*time.Second))
+	return w.Flush()
+}
+
+// keygenFunc writes a new signing key to the given file and prints the public
+// key clients verify the timecard with.
+func keygenFunc(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: timecard keygen <file>")
+	}
+	if _, err := os.Stat(args[0]); err == nil {
+		return fmt.Errorf("%s already exists", args[0])
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(args[0], []byte(hex.EncodeToString(priv)+"\n"), 0600); err != nil {
+		return err
+	}
+	log.Printf("Wrote signing key to %s, its public key is:\n\n    %s\n\n", args[0], hex.EncodeToString(pub))
+	log.Printf("Sign entries with \"git config --global timecard.signingkey %s\",\n", args[0])
+	log.Printf("verify them with \"git config timecard.verifykey %s\".\n", hex.EncodeToString(pub))
+	return nil
+}
+
+func verifyFunc(args []string) error {
+	var reseal bool
+	var key string
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.BoolVar(&reseal, "reseal", false, "accept the timecard as it is and seal every entry anew")
+	fs.StringVar(&key, "key", "", "hex encoded public key to check signatures with (timecard.verifykey)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if reseal {
+		return runJournaled(CLI.cwd, "verify --reseal", func(tc *timecard.Timecard, cfg *config.Config) error {
+			if err := tc.Reseal(); err != nil {
+				return err
+			}
+			log.Printf("Resealed %d timecard entries.\n", len(tc.Entries))
+			return nil
+		})
+	}
+
+	tc, cfg, err := openTimecard()
+	if err != nil {
+		return err
+	}
+	pub, err := cfg.VerifyKey()
+	if err != nil {
+		return err
+	}
+	if len(key) > 0 {
+		bs, err := hex.DecodeString(key)
+		if err != nil || len(bs) != ed25519.PublicKeySize {
+			return errors.New("--key must be a hex encoded ed25519 public key")
+		}
+		pub = ed25519.PublicKey(bs)
+	}
+
+	if n, ok := tc.Count(); !ok {
+		return fmt.Errorf("timecard header expects %d entries, found %d: lines were lost or edited by hand, "+
+			"\"timecard verify --reseal\" accepts the entries as they are", n, len(tc.Entries))
+	}
+	keys := []ed25519.PublicKey{}
+	if pub != nil {
+		keys = append([]ed25519.PublicKey{pub}, cfg.OldKeys()...)
+	}
+	problems := tc.Verify(keys...)
+	if len(problems) > 0 && len(problems) == len(tc.Entries) && problems[0].Kind == timecard.ProblemUnsealed {
+		return errors.New("timecard is not sealed yet, it is sealed the next time it changes or with \"timecard verify --reseal\"")
+	}
+	for _, p := range problems {
+		if p.Index >= len(tc.Entries) {
+			log.Printf("@%d end of the timecard: %s\n", p.Index, p.Kind)
+			continue
+		}
+		e := tc.Entries[p.Index]
+		log.Printf("@%d %s %s: %s\n", p.Index, e.StartTime().Format("2006-01-02 15:04"), e.Hash, p.Kind)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("verification found %d problems in %d entries", len(problems), len(tc.Entries))
+	}
+	if pub == nil {
+		log.Printf("Chain of %d entries is intact, no key to check signatures with.\n", len(tc.Entries))
+	} else {
+		log.Printf("Chain of %d entries is intact and signed.\n", len(tc.Entries))
+	}
+	if len(tc.Header.Head) > 0 {
+		log.Printf("Chain head is %s.\n", tc.Header.Head)
+	}
+	return nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
 var fnMap = map[string]cmdFn{
-	"init":       initFunc,
-	"start":      startFunc,
-	"checkpoint": checkpointFunc,
-	"end":        endFunc,
+	"init":               initFunc,
+	"start":              journaled("start", startFunc),
+	"checkpoint":         journaled("checkpoint", checkpointFunc),
+	"end":                journaled("end", endFunc),
+	"watch":              watchFunc,
+	"infer":              journaled("infer", inferFunc),
+	"timesheet":          timesheetFunc,
+	"review":             journaled("review", reviewFunc),
+	"config":             configFunc,
+	"list":               listFunc,
+	"log":                logFunc,
+	"blame":              blameFunc,
+	"stats":              statsFunc,
+	"add":                journaled("add", addFunc),
+	"amend":              journaled("amend", amendFunc),
+	"rm":                 journaled("rm", rmFunc),
+	"undo":               undoRedoFunc("undo", true),
+	"redo":               undoRedoFunc("redo", false),
+	"history":            historyFunc,
+	"report":             reportFunc,
+	"post-rewrite":       journaled("post-rewrite", postRewriteFunc),
+	"prepare-commit-msg": prepareCommitMsgFunc,
+	"commit-msg":         commitMsgFunc,
+	"import":             journaled("import", importFunc),
+	"gc":                 journaled("gc", gcFunc),
+	"serve":              serveFunc,
+	"metrics":            metricsFunc,
+	"push":               pushFunc,
+	"pull":               pullFunc,
+	"keygen":             keygenFunc,
+	"verify":             verifyFunc,
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,110 @@
+// Package registry keeps track of every repository timecard was initialized
+// in, so that reports can be aggregated across repositories.
+package registry
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	reposFile = "repos"
+)
+
+// Dir returns the directory holding timecard's per-user state, this is
+// $XDG_CONFIG_HOME/timecard or ~/.config/timecard.
+func Dir() (string, error) {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); len(xdg) > 0 {
+		return path.Join(xdg, "timecard"), nil
+	}
+	return homedir.Expand("~/.config/timecard")
+}
+
+// List returns the (absolute) paths of all registered repositories.
+func List() ([]string, error) {
+	dp, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := ioutil.ReadFile(path.Join(dp, reposFile))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	repos := []string{}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			repos = append(repos, line)
+		}
+	}
+	return repos, nil
+}
+
+func write(repos []string) error {
+	dp, err := Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dp, 0755); err != nil {
+		return err
+	}
+
+	sort.Strings(repos)
+	contents := strings.Join(repos, "\n") + "\n"
+	return ioutil.WriteFile(path.Join(dp, reposFile), []byte(contents), 0644)
+}
+
+// Add registers the repository at `dir`, registering a repository twice is a
+// no-op.
+func Add(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	repos, err := List()
+	if err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		if repo == dir {
+			return nil
+		}
+	}
+	return write(append(repos, dir))
+}
+
+// Remove forgets the repository at `dir`.
+func Remove(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	repos, err := List()
+	if err != nil {
+		return err
+	}
+	kept := []string{}
+	for _, repo := range repos {
+		if repo != dir {
+			kept = append(kept, repo)
+		}
+	}
+	return write(kept)
+}
+
+////////////////////////////////////////////////////////////////////////////////
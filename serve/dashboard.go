@@ -0,0 +1,137 @@
+package serve
+
+////////////////////////////////////////////////////////////////////////////////
+
+// dashboardHTML is the single page served at "/", it only uses the JSON API.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>timecard</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; color: #222; }
+  table { border-collapse: collapse; margin-bottom: 2em; }
+  th, td { padding: 0.2em 0.8em; text-align: left; }
+  td.num { text-align: right; font-variant-numeric: tabular-nums; }
+  th { border-bottom: 1px solid #888; }
+  .open { color: #080; font-weight: bold; }
+  .error { color: #a00; }
+  select, button { margin-right: 0.5em; }
+</style>
+</head>
+<body>
+<h1>timecard</h1>
+<p>
+  <select id="repo"></select>
+  <select id="period">
+    <option value="day">day</option>
+    <option value="week" selected>week</option>
+    <option value="month">month</option>
+  </select>
+  <select id="by">
+    <option value="day">by day</option>
+    <option value="commit">by commit</option>
+    <option value="branch">by branch</option>
+    <option value="author">by author</option>
+  </select>
+  <button data-action="start">start</button>
+  <button data-action="checkpoint">checkpoint</button>
+  <button data-action="end">end</button>
+  <span id="status"></span>
+</p>
+<table id="aggregate"></table>
+<h2>Entries</h2>
+<table id="entries"></table>
+<script>
+function $(id) { return document.getElementById(id); }
+
+function fmt(secs) {
+  var h = Math.floor(secs / 3600), m = Math.floor(secs % 3600 / 60);
+  return h > 0 ? h + "h" + (m < 10 ? "0" : "") + m + "m" : m + "m";
+}
+
+var token = "{{token}}";
+
+function api(method, endpoint, params) {
+  params.repo = $("repo").value;
+  var qs = Object.keys(params).map(function(k) {
+    return encodeURIComponent(k) + "=" + encodeURIComponent(params[k]);
+  }).join("&");
+  return fetch("/api/" + endpoint + "?" + qs, {
+    method: method,
+    headers: method === "POST" ? { "X-Timecard-Token": token } : {}
+  }).then(function(r) {
+    return r.json().then(function(body) {
+      if (!r.ok) { throw new Error(body.error); }
+      return body;
+    });
+  });
+}
+
+function row(cells, header) {
+  var tr = document.createElement("tr");
+  cells.forEach(function(c) {
+    var td = document.createElement(header ? "th" : "td");
+    if (typeof c === "number") { td.className = "num"; c = c ? fmt(c) : "-"; }
+    td.textContent = c;
+    tr.appendChild(td);
+  });
+  return tr;
+}
+
+function fill(table, head, rows) {
+  table.innerHTML = "";
+  table.appendChild(row(head, true));
+  rows.forEach(function(r) { table.appendChild(row(r)); });
+}
+
+function showError(err) {
+  $("status").className = "error";
+  $("status").textContent = err.message;
+}
+
+function refresh() {
+  var by = $("by").value;
+  api("GET", "aggregate", { by: by, period: $("period").value }).then(function(a) {
+    if (by === "day") {
+      fill($("aggregate"), ["day", "time"], a.rows.map(function(r) { return [r.label, r.seconds]; })
+        .concat([["total", a.total]]));
+    } else {
+      fill($("aggregate"), [by].concat(a.days, ["total"]), a.rows.map(function(r) {
+        return [r.label + (r.flagged ? " *" : "")].concat(r.days, [r.seconds]);
+      }));
+    }
+  }).catch(showError);
+
+  api("GET", "entries", {}).then(function(es) {
+    var open = es.length > 0 && es[es.length - 1].state === "pending";
+    $("status").className = open ? "open" : "";
+    $("status").textContent = open ? "started " + new Date(es[es.length - 1].start).toLocaleTimeString() : "";
+    fill($("entries"), ["@", "start", "time", "hash", "note"], es.slice(-50).reverse().map(function(e) {
+      return ["@" + e.index, new Date(e.start).toLocaleString(), e.seconds,
+              e.hash ? e.hash.substring(0, 7) : "(" + e.state + ")", e.note || ""];
+    }));
+  }).catch(showError);
+}
+
+fetch("/api/repos").then(function(r) { return r.json(); }).then(function(repos) {
+  repos.forEach(function(r) {
+    var o = document.createElement("option");
+    o.value = r.name;
+    o.textContent = r.name + (r.open ? " (open)" : "");
+    $("repo").appendChild(o);
+  });
+  refresh();
+});
+
+["repo", "period", "by"].forEach(function(id) { $(id).onchange = refresh; });
+document.querySelectorAll("button").forEach(function(b) {
+  b.onclick = function() { api("POST", b.dataset.action, {}).then(refresh).catch(showError); };
+});
+setInterval(refresh, 60000);
+</script>
+</body>
+</html>
+`
+
+////////////////////////////////////////////////////////////////////////////////
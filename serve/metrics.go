@@ -0,0 +1,156 @@
+package serve
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// OpenMetricsContentType is served at /metrics.
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// entryStates are the states reported by timecard_entries, see
+// timecard.Entry.StateName.
+var entryStates = []string{"pending", "partial", "hashed", "unknown"}
+
+// metricFamily collects the samples of one metric.
+type metricFamily struct {
+	name, kind, unit, help string
+	samples                []string
+}
+
+func (f *metricFamily) add(value float64, labels ...string) {
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], escapeLabel(labels[i+1])))
+	}
+	f.samples = append(f.samples, fmt.Sprintf("%s{%s} %g", f.name, strings.Join(pairs, ","), value))
+}
+
+// escapeLabel replaces control characters, %q would escape them in ways the
+// exposition formats do not understand.
+func escapeLabel(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// WriteMetrics writes gauges of the open sessions, of the time tracked per
+// repository, author and branch, and entry counts by state to `w`.  The
+// output is OpenMetrics when `openMetrics` is set, otherwise the Prometheus
+// text format read by node_exporter's textfile collector.
+func (s *Server) WriteMetrics(w io.Writer, openMetrics bool) error {
+	up := &metricFamily{name: "timecard_up", kind: "gauge",
+		help: "Whether the repository's timecard could be loaded."}
+	open := &metricFamily{name: "timecard_session_open", kind: "gauge",
+		help: "Whether an entry was started and not ended yet."}
+	session := &metricFamily{name: "timecard_session_seconds", kind: "gauge", unit: "seconds",
+		help: "Duration of the open entry, 0 if there is none."}
+	tracked := &metricFamily{name: "timecard_tracked_seconds", kind: "gauge", unit: "seconds",
+		help: "Time tracked per author and branch, uncommitted time is on branch \"(uncommitted)\"."}
+	entries := &metricFamily{name: "timecard_entries", kind: "gauge",
+		help: "Number of timecard entries by state."}
+
+	now := time.Now()
+	for _, repo := range s.Repos {
+		tc, cfg, err := s.Open(repo.Dir)
+		if err != nil {
+			up.add(0, "repo", repo.Name)
+			continue
+		}
+		tallies, err := tc.Tallies(now, cfg.User())
+		if err != nil {
+			up.add(0, "repo", repo.Name)
+			continue
+		}
+		up.add(1, "repo", repo.Name)
+
+		var isOpen, secs float64
+		if n := len(tc.Entries); n > 0 && tc.Entries[n-1].StateName() == "pending" {
+			isOpen, secs = 1, tc.Entries[n-1].Duration(now).Seconds()
+		}
+		open.add(isOpen, "repo", repo.Name)
+		session.add(secs, "repo", repo.Name)
+
+		sort.Slice(tallies, func(i, j int) bool {
+			if tallies[i].Author != tallies[j].Author {
+				return tallies[i].Author < tallies[j].Author
+			}
+			return tallies[i].Branch < tallies[j].Branch
+		})
+		for _, t := range tallies {
+			tracked.add(t.Duration.Seconds(), "repo", repo.Name, "author", t.Author, "branch", t.Branch)
+		}
+
+		counts := map[string]int{}
+		for _, e := range tc.Entries {
+			counts[e.StateName()]++
+		}
+		for _, state := range entryStates {
+			entries.add(float64(counts[state]), "repo", repo.Name, "state", state)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range []*metricFamily{up, open, session, tracked, entries} {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+		if openMetrics && len(f.unit) > 0 {
+			fmt.Fprintf(bw, "# UNIT %s %s\n", f.name, f.unit)
+		}
+		for _, sample := range f.samples {
+			fmt.Fprintln(bw, sample)
+		}
+	}
+	if openMetrics {
+		fmt.Fprintln(bw, "# EOF")
+	}
+	return bw.Flush()
+}
+
+// WriteTextfile atomically replaces `fp` with the current metrics, for use
+// with node_exporter's textfile collector.
+func (s *Server) WriteTextfile(fp string) error {
+	f, err := ioutil.TempFile(filepath.Dir(fp), ".timecard-metrics")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := s.WriteMetrics(f, false); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fp)
+}
+
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	if err := s.WriteMetrics(w, true); err != nil {
+		writeError(w, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,524 @@
+// Package serve exposes timecards over a local HTTP server: a JSON API for
+// editor plugins and scripts, and a small dashboard.
+package serve
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sabhiram/timecard/config"
+	"github.com/sabhiram/timecard/timecard"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// OpenFn loads the timecard of the repository at `dir`.
+type OpenFn func(dir string) (*timecard.Timecard, *config.Config, error)
+
+// DoFn runs `fn` on the locked timecard of the repository at `dir` and records
+// the change as `cmd`.
+type DoFn func(dir, cmd string, fn func(*timecard.Timecard, *config.Config) error) error
+
+// Repo is a repository served by the Server.
+type Repo struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+}
+
+// TokenHeader is the header which carries the server's Token in requests
+// which change a timecard.
+const TokenHeader = "X-Timecard-Token"
+
+// Server serves the timecards of a set of repositories.
+type Server struct {
+	Repos []*Repo
+	Open  OpenFn
+	Do    DoFn
+	Token string // Required in the TokenHeader of POST requests
+	Port  string // Port requests must be addressed to, any if empty
+}
+
+// New returns a server for the repositories at `dirs`.  Repositories are
+// named after their directory, along with as many of its parents as it takes
+// to tell them apart.  The server gets a random token, which the dashboard it
+// serves knows about.
+func New(dirs []string, open OpenFn, do DoFn) *Server {
+	s := &Server{Open: open, Do: do}
+	for _, dir := range dirs {
+		s.Repos = append(s.Repos, &Repo{Name: repoName(dir, dirs), Dir: dir})
+	}
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err == nil {
+		s.Token = hex.EncodeToString(bs)
+	}
+	return s
+}
+
+// repoName returns the shortest trailing part of `dir` which no other
+// directory of `dirs` ends in.
+func repoName(dir string, dirs []string) string {
+	parts := strings.Split(path.Clean(dir), "/")
+	for n := 1; n < len(parts); n++ {
+		name := strings.Join(parts[len(parts)-n:], "/")
+		unique := true
+		for _, other := range dirs {
+			if other != dir && (path.Clean(other) == name || strings.HasSuffix(path.Clean(other), "/"+name)) {
+				unique = false
+			}
+		}
+		if unique {
+			return name
+		}
+	}
+	return dir
+}
+
+// Handler returns the http.Handler serving the API and the dashboard.  Only
+// requests from the loopback interface, addressed to it by name, are served.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.dashboard)
+	mux.HandleFunc("/metrics", s.metrics)
+	mux.HandleFunc("/api/repos", s.get(s.repos))
+	mux.HandleFunc("/api/entries", s.get(s.entries))
+	mux.HandleFunc("/api/commits", s.get(s.commits))
+	mux.HandleFunc("/api/aggregate", s.get(s.aggregate))
+	mux.HandleFunc("/api/start", s.post("start", (*timecard.Timecard).Start))
+	mux.HandleFunc("/api/end", s.post("end", (*timecard.Timecard).End))
+	mux.HandleFunc("/api/checkpoint", s.post("checkpoint", (*timecard.Timecard).Checkpoint))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loopbackAddr(r.RemoteAddr) {
+			writeError(w, &httpError{http.StatusForbidden, "requests must come from localhost"})
+			return
+		}
+		if !s.loopback(r.Host) {
+			writeError(w, &httpError{http.StatusForbidden, "requests must be addressed to localhost"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// loopback returns true if `host`, the Host header of a request, names the
+// loopback interface and the server's port.  A web page whose domain was
+// rebound to 127.0.0.1 sends its own domain and is turned away.
+func (s *Server) loopback(host string) bool {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = strings.Trim(host, "[]"), "80"
+	}
+	switch name {
+	case "localhost", "127.0.0.1", "::1":
+		return len(s.Port) == 0 || port == s.Port
+	}
+	return false
+}
+
+// loopbackAddr returns true if `addr`, a "host:port" address, is on the
+// loopback interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// httpError is an error with an associated HTTP status code.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if he, ok := err.(*httpError); ok {
+		code = he.code
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+type handlerFn func(r *http.Request) (interface{}, error)
+
+func (s *Server) get(fn handlerFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, &httpError{http.StatusMethodNotAllowed, "use GET"})
+			return
+		}
+		v, err := fn(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// sameOrigin rejects requests made by pages served from elsewhere, browsers
+// would otherwise let any website start and end entries.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (s *Server) post(cmd string, fn func(*timecard.Timecard) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, &httpError{http.StatusMethodNotAllowed, "use POST"})
+			return
+		}
+		if !sameOrigin(r) {
+			writeError(w, &httpError{http.StatusForbidden, "cross-origin requests are not allowed"})
+			return
+		}
+		if len(s.Token) == 0 || subtle.ConstantTimeCompare([]byte(r.Header.Get(TokenHeader)), []byte(s.Token)) != 1 {
+			writeError(w, &httpError{http.StatusForbidden, "missing or wrong " + TokenHeader + " header"})
+			return
+		}
+		repo, err := s.repo(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		var last *Entry
+		err = s.Do(repo.Dir, cmd, func(tc *timecard.Timecard, cfg *config.Config) error {
+			if err := fn(tc); err != nil {
+				return badRequest("%s", err.Error())
+			}
+			if n := len(tc.Entries); n > 0 {
+				last = newEntry(tc, n-1, tc.Now())
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, last)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// repo returns the repository selected by the "repo" query parameter, which
+// may be omitted when only one repository is served.
+func (s *Server) repo(r *http.Request) (*Repo, error) {
+	name := r.URL.Query().Get("repo")
+	if len(name) == 0 {
+		if len(s.Repos) == 1 {
+			return s.Repos[0], nil
+		}
+		return nil, badRequest("\"repo\" is required when serving several repositories")
+	}
+	for _, repo := range s.Repos {
+		if repo.Name == name || repo.Dir == name {
+			return repo, nil
+		}
+	}
+	return nil, &httpError{http.StatusNotFound, fmt.Sprintf("unknown repo %q", name)}
+}
+
+func (s *Server) open(r *http.Request) (*Repo, *timecard.Timecard, *config.Config, error) {
+	repo, err := s.repo(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tc, cfg, err := s.Open(repo.Dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return repo, tc, cfg, nil
+}
+
+// Entry is the JSON representation of a timecard entry.
+type Entry struct {
+	Index       int        `json:"index"`
+	Start       time.Time  `json:"start"`
+	End         *time.Time `json:"end,omitempty"`
+	Seconds     int64      `json:"seconds"`
+	Hash        string     `json:"hash,omitempty"`
+	State       string     `json:"state"`
+	Accepted    bool       `json:"accepted,omitempty"`
+	Note        string     `json:"note,omitempty"`
+	Checkpoints []int64    `json:"checkpoints,omitempty"` // Unix seconds
+}
+
+// newEntry returns the entry at `idx`, counting its time like the timesheet
+// does.
+func newEntry(tc *timecard.Timecard, idx int, now time.Time) *Entry {
+	e := tc.Entries[idx]
+	start, end := tc.Rules.Clamp(e, now)
+	ej := &Entry{
+		Index:    idx,
+		Start:    e.StartTime(),
+		Seconds:  int64(end.Sub(start) / time.Second),
+		Hash:     e.Hash,
+		State:    e.StateName(),
+		Accepted: e.Accepted,
+		Note:     e.Note,
+	}
+	for _, cp := range e.Checkpoints {
+		ej.Checkpoints = append(ej.Checkpoints, cp.Unix())
+	}
+	if !e.End.IsZero() {
+		end := e.EndTime(now)
+		ej.End = &end
+	}
+	return ej
+}
+
+type repoStatus struct {
+	*Repo
+	Open    bool   `json:"open"`            // An entry was started and not ended
+	Error   string `json:"error,omitempty"` // Why the timecard could not be loaded
+	Entries int    `json:"entries"`
+}
+
+func (s *Server) repos(r *http.Request) (interface{}, error) {
+	rs := []*repoStatus{}
+	for _, repo := range s.Repos {
+		st := &repoStatus{Repo: repo}
+		rs = append(rs, st)
+		tc, _, err := s.Open(repo.Dir)
+		if err != nil {
+			st.Error = err.Error()
+			continue
+		}
+		st.Entries = len(tc.Entries)
+		if n := len(tc.Entries); n > 0 {
+			st.Open = tc.Entries[n-1].StateName() == "pending"
+		}
+	}
+	return rs, nil
+}
+
+func (s *Server) entries(r *http.Request) (interface{}, error) {
+	_, tc, _, err := s.open(r)
+	if err != nil {
+		return nil, err
+	}
+	now := tc.Now()
+	es := []*Entry{}
+	for i := range tc.Entries {
+		es = append(es, newEntry(tc, i, now))
+	}
+	return es, nil
+}
+
+type commitTotal struct {
+	Hash    string     `json:"hash"`
+	Subject string     `json:"subject,omitempty"`
+	Author  string     `json:"author,omitempty"`
+	When    *time.Time `json:"when,omitempty"`
+	Branch  string     `json:"branch,omitempty"`
+	Seconds int64      `json:"seconds"`
+	Entries int        `json:"entries"`
+}
+
+func (s *Server) commits(r *http.Request) (interface{}, error) {
+	_, tc, _, err := s.open(r)
+	if err != nil {
+		return nil, err
+	}
+	branches, err := tc.Repo().CommitBranches()
+	if err != nil {
+		return nil, err
+	}
+
+	times := tc.CommitTimes(tc.Now())
+	byHash := map[string]*commitTotal{}
+	cs := []*commitTotal{}
+	for _, e := range tc.Entries {
+		if e.StateName() != "hashed" {
+			continue
+		}
+		ct, ok := byHash[e.Hash]
+		if !ok {
+			ct = &commitTotal{Hash: e.Hash, Branch: branches[e.Hash]}
+			if c, err := tc.Repo().Commit(e.Hash); err == nil {
+				ct.Subject, ct.Author, ct.When = c.Subject(), c.Author, &c.When
+			}
+			ct.Seconds = int64(times[e.Hash] / time.Second)
+			byHash[e.Hash] = ct
+			cs = append(cs, ct)
+		}
+		ct.Entries++
+	}
+	return cs, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type aggregateRow struct {
+	Label   string  `json:"label"`
+	Days    []int64 `json:"days,omitempty"` // Seconds per day of the period
+	Seconds int64   `json:"seconds"`
+	Flagged bool    `json:"flagged,omitempty"`
+}
+
+type aggregate struct {
+	By    string          `json:"by"`
+	Days  []string        `json:"days"`
+	Rows  []*aggregateRow `json:"rows"`
+	Total int64           `json:"total"`
+}
+
+// options returns the timesheet options selected by the "period", "date" and
+// "tz" query parameters, falling back on the configuration in `cfg`.
+func options(r *http.Request, cfg *config.Config) (timecard.TimesheetOptions, error) {
+	q := r.URL.Query()
+	opts := timecard.TimesheetOptions{
+		Period:    timecard.PeriodWeek,
+		Location:  cfg.Location(),
+		WeekStart: cfg.WeekStart(),
+		User:      cfg.User(),
+	}
+	switch q.Get("period") {
+	case "", "week":
+	case "day":
+		opts.Period = timecard.PeriodDay
+	case "month":
+		opts.Period = timecard.PeriodMonth
+	default:
+		return opts, badRequest("period must be day, week or month")
+	}
+
+	var err error
+	if tz := q.Get("tz"); len(tz) > 0 {
+		if opts.Location, err = time.LoadLocation(tz); err != nil {
+			return opts, badRequest("%s", err.Error())
+		}
+	}
+	if date := q.Get("date"); len(date) > 0 {
+		if opts.At, err = time.ParseInLocation("2006-01-02", date, opts.Location); err != nil {
+			return opts, badRequest("date must be YYYY-MM-DD")
+		}
+	}
+	return opts, nil
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+func (s *Server) aggregate(r *http.Request) (interface{}, error) {
+	_, tc, cfg, err := s.open(r)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := options(r, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "", "day":
+		by = "day"
+	case "commit":
+		opts.GroupBy = timecard.GroupByCommit
+	case "branch":
+		opts.GroupBy = timecard.GroupByBranch
+	case "author":
+		opts.GroupBy = timecard.GroupByAuthor
+	default:
+		return nil, badRequest("by must be day, commit, branch or author")
+	}
+
+	ts, err := tc.Timesheet(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &aggregate{By: by, Rows: []*aggregateRow{}, Total: seconds(ts.Total())}
+	for _, day := range ts.Days {
+		a.Days = append(a.Days, day.Format("2006-01-02"))
+	}
+	if by == "day" {
+		for i, d := range ts.DayTotals() {
+			a.Rows = append(a.Rows, &aggregateRow{Label: a.Days[i], Seconds: seconds(d)})
+		}
+		return a, nil
+	}
+	for _, row := range ts.Rows {
+		ar := &aggregateRow{Label: row.Label, Seconds: seconds(row.Total), Flagged: row.Flagged}
+		for _, d := range row.Days {
+			ar.Days = append(ar.Days, seconds(d))
+		}
+		a.Rows = append(a.Rows, ar)
+	}
+	sort.SliceStable(a.Rows, func(i, j int) bool {
+		return a.Rows[i].Seconds > a.Rows[j].Seconds
+	})
+	return a, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (s *Server) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, &httpError{http.StatusNotFound, "not found"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, strings.Replace(dashboardHTML, "{{token}}", s.Token, 1))
+}
+
+// ListenAndServe serves the API and the dashboard on `addr` until an error
+// occurs.  `addr` must be on the loopback interface, and requests must be
+// addressed to the port listened on.
+func (s *Server) ListenAndServe(addr string) error {
+	if len(s.Repos) == 0 {
+		return errors.New("no repositories to serve")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if !loopbackAddr(l.Addr().String()) {
+		l.Close()
+		return fmt.Errorf("refusing to listen on %s, which is not the loopback interface", l.Addr())
+	}
+	_, s.Port, _ = net.SplitHostPort(l.Addr().String())
+	return http.Serve(l, s.Handler())
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,60 @@
+package serve
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestLoopbackOnly(t *testing.T) {
+	s := &Server{Token: "secret", Port: "7070"}
+	h := s.Handler()
+
+	for _, tt := range []struct {
+		remote, host string
+		code         int
+	}{
+		{"127.0.0.1:5000", "localhost:7070", http.StatusOK},
+		{"[::1]:5000", "[::1]:7070", http.StatusOK},
+		{"192.0.2.1:5000", "localhost:7070", http.StatusForbidden},
+		{"127.0.0.1:5000", "evil.example.com:7070", http.StatusForbidden},
+		{"127.0.0.1:5000", "localhost:8080", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr, r.Host = tt.remote, tt.host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s to %s: status %d, want %d", tt.remote, tt.host, w.Code, tt.code)
+		}
+		if leaked := strings.Contains(w.Body.String(), s.Token); leaked != (tt.code == http.StatusOK) {
+			t.Errorf("%s to %s: dashboard token served %v", tt.remote, tt.host, leaked)
+		}
+	}
+}
+
+func TestRepoName(t *testing.T) {
+	dirs := []string{"/home/me/work/api", "/home/me/oss/api", "/home/me/oss/web", "/srv/api"}
+	want := []string{"work/api", "oss/api", "web", "srv/api"}
+	for i, dir := range dirs {
+		if got := repoName(dir, dirs); got != want[i] {
+			t.Errorf("repoName(%q) = %q, want %q", dir, got, want[i])
+		}
+	}
+}
+
+func TestListenLoopbackOnly(t *testing.T) {
+	s := &Server{Repos: []*Repo{{Name: "repo", Dir: t.TempDir()}}}
+	for _, addr := range []string{":0", "0.0.0.0:0"} {
+		if err := s.ListenAndServe(addr); err == nil || !strings.Contains(err.Error(), "not the loopback interface") {
+			t.Errorf("listen on %s: %v", addr, err)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
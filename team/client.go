@@ -0,0 +1,84 @@
+package team
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Client talks to a team server at URL.
+type Client struct {
+	URL   string
+	Token string
+	HTTP  *http.Client
+}
+
+// NewClient returns a client for the server at `server`.
+func NewClient(server, token string) (*Client, error) {
+	if len(server) == 0 {
+		return nil, errors.New("no team server configured, set timecard.server")
+	}
+	if len(token) == 0 {
+		return nil, errors.New("no API token configured, set timecard.token")
+	}
+	return &Client{
+		URL:   strings.TrimSuffix(server, "/"),
+		Token: token,
+		HTTP:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// do sends a request to `endpoint` and decodes the JSON reply into `out`.
+func (c *Client) do(method, endpoint string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.URL+endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg := map[string]string{}
+		if json.NewDecoder(resp.Body).Decode(&msg) == nil && len(msg["error"]) > 0 {
+			return fmt.Errorf("team server: %s", msg["error"])
+		}
+		return fmt.Errorf("team server: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Push uploads `rs` to the server.
+func (c *Client) Push(rs []*Record) (*PushResult, error) {
+	res := &PushResult{}
+	return res, c.do(http.MethodPost, "/api/v1/records", rs, res)
+}
+
+// Records downloads the records of `project`, or of every project if it is
+// empty.
+func (c *Client) Records(project string) ([]*Record, error) {
+	rs := []*Record{}
+	return rs, c.do(http.MethodGet, "/api/v1/records?project="+url.QueryEscape(project), nil, &rs)
+}
+
+////////////////////////////////////////////////////////////////////////////////
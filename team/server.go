@@ -0,0 +1,313 @@
+package team
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	recordsFile = "records"
+	tokensFile  = "tokens"
+
+	maxPushSize = 32 << 20
+)
+
+// DB is the server's record store, a file of JSON records (one per line) which
+// is kept in memory and rewritten on every push.
+type DB struct {
+	Path    string
+	mu      sync.RWMutex
+	records map[string]*Record
+}
+
+// OpenDB loads the records stored in the directory `dir`.
+func OpenDB(dir string) (*DB, error) {
+	db := &DB{Path: filepath.Join(dir, recordsFile), records: map[string]*Record{}}
+	bs, err := ioutil.ReadFile(db.Path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", db.Path, n, err.Error())
+		}
+		db.records[r.Key()] = r
+	}
+	return db, scanner.Err()
+}
+
+// flush atomically rewrites the database file, the caller holds the lock.
+func (db *DB) flush() error {
+	keys := []string{}
+	for k := range db.records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBuffer(nil)
+	for _, k := range keys {
+		bs, err := json.Marshal(db.records[k])
+		if err != nil {
+			return err
+		}
+		buf.Write(append(bs, '\n'))
+	}
+
+	tmp := db.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.Path)
+}
+
+// Put stores `rs`, replacing the records with the same project, commit and
+// author.  Returns the number of records which were added and updated.
+func (db *DB) Put(rs []*Record) (int, int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	added, updated := 0, 0
+	for _, r := range rs {
+		if _, ok := db.records[r.Key()]; ok {
+			updated++
+		} else {
+			added++
+		}
+		db.records[r.Key()] = r
+	}
+	return added, updated, db.flush()
+}
+
+// Records returns the records of `project`, or of every project if it is
+// empty, ordered by commit date.
+func (db *DB) Records(project string) []*Record {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rs := []*Record{}
+	for _, r := range db.records {
+		if len(project) == 0 || r.Project == project {
+			rs = append(rs, r)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].When.Equal(rs[j].When) {
+			return rs[i].When.Before(rs[j].When)
+		}
+		return rs[i].Key() < rs[j].Key()
+	})
+	return rs
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Token is what an API token was issued for.  Records pushed with a token must
+// be authored by its Name, unless it is an Admin token.
+type Token struct {
+	Name  string
+	Admin bool
+}
+
+// LoadTokens reads the API tokens stored in the directory `dir`.  The tokens
+// file has a line "<token> <name>" per token, admin tokens are prefixed with
+// "admin ".
+func LoadTokens(dir string) (map[string]*Token, error) {
+	tokens := map[string]*Token{}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, tokensFile))
+	if os.IsNotExist(err) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		line = strings.TrimSpace(line)
+		admin := strings.HasPrefix(line, "admin ")
+		if admin {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "admin "))
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) == 2 && !strings.HasPrefix(fields[0], "#") {
+			tokens[fields[0]] = &Token{Name: strings.TrimSpace(fields[1]), Admin: admin}
+		}
+	}
+	return tokens, nil
+}
+
+// NewToken issues a new API token for `name`, an admin token if `admin` is
+// set, and stores it in the directory `dir`.
+func NewToken(dir, name string, admin bool) (string, error) {
+	if len(strings.TrimSpace(name)) == 0 {
+		return "", errors.New("tokens need a name")
+	}
+	bs := make([]byte, 24)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(bs)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, tokensFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	prefix := ""
+	if admin {
+		prefix = "admin "
+	}
+	_, err = fmt.Fprintf(f, "%s%s %s\n", prefix, token, strings.TrimSpace(name))
+	return token, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Server is the team server's HTTP API.  Every request must carry one of the
+// `Tokens` as "Authorization: Bearer <token>".  When `Dir` is set the tokens
+// are read from it, and read again whenever the tokens file changes, so that
+// new tokens work without restarting the server.
+type Server struct {
+	DB     *DB
+	Dir    string
+	Tokens map[string]*Token
+
+	mu     sync.Mutex
+	loaded os.FileInfo // Tokens file as last read
+}
+
+// PushResult is the server's reply to a push.
+type PushResult struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+}
+
+// Handler returns the http.Handler serving the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/records", s.auth(s.records))
+	mux.HandleFunc("/api/v1/report", s.auth(s.report))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// tokens returns the current tokens, reading the tokens file again if it
+// changed since it was last read.  The tokens read before are kept if it
+// cannot be read.
+func (s *Server) tokens() map[string]*Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.Dir) == 0 {
+		return s.Tokens
+	}
+	fi, err := os.Stat(filepath.Join(s.Dir, tokensFile))
+	if err != nil || (s.loaded != nil && fi.ModTime().Equal(s.loaded.ModTime()) && fi.Size() == s.loaded.Size()) {
+		return s.Tokens
+	}
+	if tokens, err := LoadTokens(s.Dir); err == nil {
+		s.Tokens, s.loaded = tokens, fi
+	}
+	return s.Tokens
+}
+
+type authedFn func(w http.ResponseWriter, r *http.Request, token *Token)
+
+// auth resolves the request's token to what it was issued for.
+func (s *Server) auth(fn authedFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for token, t := range s.tokens() {
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+				fn(w, r, t)
+				return
+			}
+		}
+		writeError(w, http.StatusUnauthorized, "invalid or missing API token")
+	}
+}
+
+func (s *Server) records(w http.ResponseWriter, r *http.Request, token *Token) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.DB.Records(r.URL.Query().Get("project")))
+	case http.MethodPost:
+		rs := []*Record{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushSize)).Decode(&rs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		now := time.Now().UTC()
+		for _, rec := range rs {
+			if err := rec.validate(); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if rec.Author != token.Name && !token.Admin {
+				writeError(w, http.StatusForbidden, fmt.Sprintf("token of %q cannot push time of %q", token.Name, rec.Author))
+				return
+			}
+			rec.PushedBy, rec.PushedAt = token.Name, now
+		}
+		added, updated, err := s.DB.Put(rs)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, &PushResult{Added: added, Updated: updated})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET or POST")
+	}
+}
+
+func (s *Server) report(w http.ResponseWriter, r *http.Request, token *Token) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	q := r.URL.Query()
+	rows, err := Report(s.DB.Records(q.Get("project")), q.Get("by"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,133 @@
+package team
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// record returns a record of `secs` seconds `author` spent on `commit`.
+func record(author, commit string, secs int64) *Record {
+	return &Record{
+		Project: "github.com/org/repo",
+		Commit:  strings.Repeat(commit, 40/len(commit)),
+		Author:  author,
+		When:    time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+		Seconds: secs,
+		Entries: 1,
+	}
+}
+
+// newTestServer starts a team server on an empty data directory along with
+// clients holding tokens of alice, bob and an admin.
+func newTestServer(t *testing.T) (alice, bob, admin *Client) {
+	dir := t.TempDir()
+	db, err := OpenDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer((&Server{DB: db, Dir: dir}).Handler())
+	t.Cleanup(hs.Close)
+
+	clients := []*Client{}
+	for _, tt := range []struct {
+		name  string
+		admin bool
+	}{{"alice", false}, {"bob", false}, {"root", true}} {
+		token, err := NewToken(dir, tt.name, tt.admin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := NewClient(hs.URL, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+	return clients[0], clients[1], clients[2]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestPushPull(t *testing.T) {
+	alice, bob, _ := newTestServer(t)
+
+	res, err := alice.Push([]*Record{record("alice", "a", 60), record("alice", "b", 120)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Added != 2 || res.Updated != 0 {
+		t.Errorf("first push: added %d, updated %d", res.Added, res.Updated)
+	}
+	res, err = alice.Push([]*Record{record("alice", "a", 90)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Added != 0 || res.Updated != 1 {
+		t.Errorf("second push: added %d, updated %d", res.Added, res.Updated)
+	}
+	if _, err := bob.Push([]*Record{record("bob", "a", 30)}); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := bob.Records("github.com/org/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, r := range rs {
+		got = append(got, r.Author+":"+r.Commit[:1]+":"+time.Duration(r.Seconds*int64(time.Second)).String()+":"+r.PushedBy)
+	}
+	if strings.Join(got, " ") != "alice:a:1m30s:alice bob:a:30s:bob alice:b:2m0s:alice" {
+		t.Errorf("records: %q", got)
+	}
+	if rs, err := bob.Records("github.com/org/other"); err != nil || len(rs) != 0 {
+		t.Errorf("records of another project: %d, %v", len(rs), err)
+	}
+}
+
+func TestBadToken(t *testing.T) {
+	alice, _, _ := newTestServer(t)
+
+	bad := *alice
+	bad.Token = "0123"
+	if _, err := bad.Records(""); err == nil || !strings.Contains(err.Error(), "invalid or missing API token") {
+		t.Errorf("pull with a bad token: %v", err)
+	}
+	if _, err := bad.Push([]*Record{record("alice", "a", 60)}); err == nil {
+		t.Errorf("push with a bad token succeeded")
+	}
+}
+
+func TestCrossAuthor(t *testing.T) {
+	alice, bob, admin := newTestServer(t)
+
+	if _, err := alice.Push([]*Record{record("alice", "a", 60)}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := bob.Push([]*Record{record("bob", "b", 30), record("alice", "a", 1)})
+	if err == nil || !strings.Contains(err.Error(), "cannot push time of") {
+		t.Errorf("bob overwriting alice's record: %v", err)
+	}
+	rs, err := alice.Records("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].Seconds != 60 {
+		t.Errorf("records changed by a rejected push: %+v", rs)
+	}
+
+	if _, err := admin.Push([]*Record{record("alice", "a", 45)}); err != nil {
+		t.Errorf("admin push: %v", err)
+	}
+	if rs, err := alice.Records(""); err != nil || len(rs) != 1 || rs[0].Seconds != 45 || rs[0].PushedBy != "root" {
+		t.Errorf("records after the admin push: %+v, %v", rs, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
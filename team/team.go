@@ -0,0 +1,159 @@
+// Package team shares timecards through a team server: developers push the
+// time they spent per commit and pull back everyone's totals.
+package team
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sabhiram/timecard/timecard"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Record is the time one developer spent on one commit of a project.  The
+// server keeps a single record per project, commit and author, pushing a
+// record again replaces it.
+type Record struct {
+	Project  string    `json:"project"`
+	Commit   string    `json:"commit"`
+	Author   string    `json:"author"` // Developer who tracked the time
+	Subject  string    `json:"subject,omitempty"`
+	When     time.Time `json:"when"` // Author date of the commit
+	Seconds  int64     `json:"seconds"`
+	Entries  int       `json:"entries"`
+	PushedBy string    `json:"pushed_by,omitempty"` // Name of the token, set by the server
+	PushedAt time.Time `json:"pushed_at,omitempty"` // Set by the server
+}
+
+// Key returns the identity under which the server stores the record.
+func (r *Record) Key() string {
+	return r.Project + "\x00" + r.Commit + "\x00" + r.Author
+}
+
+func (r *Record) validate() error {
+	switch {
+	case len(r.Project) == 0:
+		return errors.New("record without project")
+	case len(r.Commit) != 40:
+		return fmt.Errorf("record with invalid commit %q", r.Commit)
+	case len(r.Author) == 0:
+		return errors.New("record without author")
+	case r.Seconds < 0:
+		return fmt.Errorf("record for %s has negative time", r.Commit)
+	}
+	return nil
+}
+
+// ProjectName derives a project name from a remote URL, so that clones of the
+// same repository agree on it: "git@github.com:org/repo.git" and
+// "https://github.com/org/repo" both become "github.com/org/repo".
+func ProjectName(remote string) string {
+	name := remote
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	} else if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i] + "/" + name[i+1:] // scp-like syntax
+	}
+	if i := strings.Index(name, "@"); i >= 0 && i < strings.Index(name+"/", "/") {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(name, "/"), ".git")
+}
+
+// Records summarizes the time `author` tracked per commit in the timecard
+// `tc`.  Entries which were not committed yet are left out, entries which
+// break the timecard's rules only count for their clamped span.
+func Records(tc *timecard.Timecard, project, author string, now time.Time) ([]*Record, error) {
+	if len(author) == 0 {
+		return nil, errors.New("set git's user.name to push timecards")
+	}
+
+	byHash := map[string]*Record{}
+	rs := []*Record{}
+	for _, e := range tc.Entries {
+		if e.StateName() != "hashed" {
+			continue
+		}
+		r, ok := byHash[e.Hash]
+		if !ok {
+			c, err := tc.Repo().Commit(e.Hash)
+			if err != nil {
+				continue // Commit is gone, see "timecard gc"
+			}
+			r = &Record{
+				Project: project,
+				Commit:  c.Hash,
+				Author:  author,
+				Subject: c.Subject(),
+				When:    c.When,
+			}
+			byHash[e.Hash] = r
+			rs = append(rs, r)
+		}
+		start, end := tc.Rules.Clamp(e, now)
+		if end.After(start) {
+			r.Seconds += int64(end.Sub(start) / time.Second)
+		}
+		r.Entries++
+	}
+	return rs, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ReportRow is the time spent per author, commit or project.
+type ReportRow struct {
+	Label   string `json:"label"`
+	Seconds int64  `json:"seconds"`
+	Commits int    `json:"commits"`
+	Authors int    `json:"authors"`
+}
+
+// Report groups `records` by "author", "commit" or "project", the rows are
+// sorted by decreasing time.
+func Report(records []*Record, by string) ([]*ReportRow, error) {
+	labelFn := func(r *Record) string { return r.Author }
+	switch by {
+	case "", "author":
+	case "commit":
+		labelFn = func(r *Record) string {
+			return fmt.Sprintf("%s %s %s", path.Base(r.Project), r.Commit[:7], r.Subject)
+		}
+	case "project":
+		labelFn = func(r *Record) string { return r.Project }
+	default:
+		return nil, fmt.Errorf("cannot report by %q, use author, commit or project", by)
+	}
+
+	type seen struct{ commits, authors map[string]bool }
+	rows := map[string]*ReportRow{}
+	sets := map[string]*seen{}
+	rs := []*ReportRow{}
+	for _, r := range records {
+		label := labelFn(r)
+		row, ok := rows[label]
+		if !ok {
+			row = &ReportRow{Label: label}
+			rows[label] = row
+			sets[label] = &seen{map[string]bool{}, map[string]bool{}}
+			rs = append(rs, row)
+		}
+		row.Seconds += r.Seconds
+		sets[label].commits[r.Project+r.Commit] = true
+		sets[label].authors[r.Author] = true
+		row.Commits, row.Authors = len(sets[label].commits), len(sets[label].authors)
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].Seconds > rs[j].Seconds
+	})
+	return rs, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
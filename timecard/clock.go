@@ -0,0 +1,45 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Clock tells the timecard what time it is.  Entries are started, ended and
+// checkpointed at the clock's time, which lets them be backdated and tests
+// run at fixed times.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the computer's clock, the default for every timecard.
+var SystemClock Clock = systemClock{}
+
+// FixedClock is a clock stopped at the given time.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Now returns the current time according to the timecard's clock, without
+// the monotonic clock reading so that it can be recorded in entries.
+func (tc *Timecard) Now() time.Time {
+	if tc.Clock == nil {
+		return SystemClock.Now().Round(0)
+	}
+	return tc.Clock.Now().Round(0)
+}
+
+////////////////////////////////////////////////////////////////////////////////
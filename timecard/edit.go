@@ -0,0 +1,145 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+var (
+	ErrNoEntry        = errors.New("no matching timecard entry")
+	ErrAmbiguousEntry = errors.New("selector matches more than one timecard entry")
+)
+
+// Find returns the index of the entry selected by `sel`.  Selectors are
+// either "@N" for the N-th entry (negative values count from the end, "@-1"
+// is the last entry) or a prefix of the entry's commit hash.  A hash only
+// selects commits worked on in a single entry, the error returned for the
+// others (wrapping ErrAmbiguousEntry) lists the indices of their entries.
+func (tc *Timecard) Find(sel string) (int, error) {
+	if strings.HasPrefix(sel, "@") {
+		idx, err := strconv.Atoi(sel[1:])
+		if err != nil {
+			return -1, fmt.Errorf("invalid entry index %q", sel)
+		}
+		if idx < 0 {
+			idx += len(tc.Entries)
+		}
+		if idx < 0 || idx >= len(tc.Entries) {
+			return -1, ErrNoEntry
+		}
+		return idx, nil
+	}
+
+	found := []string{}
+	idx := -1
+	for i, e := range tc.Entries {
+		if len(sel) > 0 && len(e.Hash) > 0 && strings.HasPrefix(e.Hash, strings.ToLower(sel)) {
+			found = append(found, fmt.Sprintf("@%d", i))
+			idx = i
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return -1, ErrNoEntry
+	case len(found) > 1:
+		return -1, fmt.Errorf("%w (%s), select one of them by index", ErrAmbiguousEntry, strings.Join(found, ", "))
+	}
+	return idx, nil
+}
+
+// checkSpan returns an error if the entry `e` ends before it starts.
+func checkSpan(e *Entry) error {
+	if !e.End.IsZero() && !e.Start.Before(e.End) {
+		return fmt.Errorf("entry would end (%s) before it starts (%s)",
+			e.End.Format("2006-01-02 15:04"), e.Start.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// resetState derives the entry's state from which of its fields are set.
+func (e *Entry) resetState() {
+	switch {
+	case e.End.IsZero():
+		e.State = cStatePending
+	case len(e.Hash) == 0:
+		e.State = cStatePartial
+	default:
+		e.State = cStateHashed
+	}
+}
+
+// Add inserts a completed entry in chronological order and returns its index.
+// Added entries are always placed before a trailing open (pending or partial)
+// entry so that `start` and `end` keep working on the current entry.
+func (tc *Timecard) Add(e *Entry) (int, error) {
+	if err := tc.insert([]*Entry{e}); err != nil {
+		return -1, err
+	}
+	for i, other := range tc.Entries {
+		if other == e {
+			return i, nil
+		}
+	}
+	return -1, ErrNoEntry
+}
+
+// insert adds completed entries as Add does, flushing the timecard once.
+func (tc *Timecard) insert(es []*Entry) error {
+	for _, e := range es {
+		if e.Start.IsZero() || e.End.IsZero() || len(e.Hash) == 0 {
+			return errors.New("added entries need a start, an end and a commit hash")
+		}
+		if err := checkSpan(e); err != nil {
+			return err
+		}
+	}
+	if len(es) == 0 {
+		return nil
+	}
+
+	for _, e := range es {
+		e.resetState()
+		limit := len(tc.Entries)
+		if limit > 0 && tc.Entries[limit-1].State != cStateHashed {
+			limit--
+		}
+		idx := sort.Search(limit, func(i int) bool {
+			return tc.Entries[i].Start.After(e.Start)
+		})
+
+		tc.Entries = append(tc.Entries[:idx], append([]*Entry{e}, tc.Entries[idx:]...)...)
+		tc.Header.Count += 1
+	}
+	return tc.Flush()
+}
+
+// Update persists changes made to the entry at `idx`, re-deriving its state.
+func (tc *Timecard) Update(idx int) error {
+	if idx < 0 || idx >= len(tc.Entries) {
+		return ErrNoEntry
+	}
+	if err := checkSpan(tc.Entries[idx]); err != nil {
+		return err
+	}
+	tc.Entries[idx].resetState()
+	return tc.Flush()
+}
+
+// Remove deletes the entry at `idx`.
+func (tc *Timecard) Remove(idx int) error {
+	if idx < 0 || idx >= len(tc.Entries) {
+		return ErrNoEntry
+	}
+	tc.Entries = append(tc.Entries[:idx], tc.Entries[idx+1:]...)
+	tc.Header.Count -= 1
+	return tc.Flush()
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,88 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/sabhiram/timecard/git"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Inference is a proposed start for the open entry, worked out from the
+// worktree when someone forgot to run "timecard start".
+type Inference struct {
+	Start   time.Time     // Proposed start, zero if there is nothing to go by
+	Reason  string        // Why Start was chosen
+	Since   time.Time     // Changes saved before this belong to earlier work
+	Changes []*git.Change // Changed files, saved since Since or not
+	Current *Entry        // The open entry, nil if there is none
+}
+
+// Infer proposes a start time for the current change set.  Work on it began
+// no earlier than the last move of HEAD (a commit, checkout, reset, ...) nor
+// the end of the last entry.  Within that, the first changed file which was
+// saved since marks the start, unless it was saved within `gap` of that bound,
+// in which case the work is taken to have followed on directly.
+func (tc *Timecard) Infer(gap time.Duration) (*Inference, error) {
+	if tc.repo == nil {
+		return nil, errors.New("inferring a start requires a git repository")
+	}
+	inf := &Inference{}
+
+	reflog, err := tc.repo.Reflog()
+	if err != nil {
+		return nil, err
+	}
+	bound := "the repository was created"
+	if n := len(reflog); n > 0 {
+		inf.Since, bound = reflog[n-1].When, fmt.Sprintf("HEAD moved (%s)", reflog[n-1].Message)
+	}
+	for i := len(tc.Entries) - 1; i >= 0; i-- {
+		e := tc.Entries[i]
+		if e.State == cStatePending {
+			inf.Current = e
+			continue
+		}
+		if e.End.After(inf.Since) {
+			inf.Since, bound = e.End, "the last entry ended"
+		}
+		break
+	}
+
+	changes, err := tc.repo.Changes()
+	if err != nil {
+		return nil, err
+	}
+	first := time.Time{}
+	for _, c := range changes {
+		if fs, ok := tc.store.(*FileStore); ok && path.Join(tc.repo.Worktree(), c.Path) == fs.Path {
+			continue // Changed by timecard itself
+		}
+		inf.Changes = append(inf.Changes, c)
+		if c.ModTime.After(inf.Since) && (first.IsZero() || c.ModTime.Before(first)) {
+			first = c.ModTime
+		}
+	}
+
+	switch {
+	case first.IsZero():
+		inf.Reason = "no changed file was saved since " + bound
+	case !inf.Since.IsZero() && first.Sub(inf.Since) <= gap:
+		inf.Start = inf.Since
+		inf.Reason = fmt.Sprintf("changes were saved within %s of when %s", FormatDuration(gap), bound)
+	default:
+		inf.Start = first
+		inf.Reason = "first changed file saved since " + bound
+	}
+	if now := tc.Now(); inf.Start.After(now) {
+		inf.Start = now
+	}
+	return inf, nil
+}
+
+////////
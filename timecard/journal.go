@@ -0,0 +1,346 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	journalFile   = "journal"
+	maxJournalOps = 1000 // Oldest operations are dropped past this point
+)
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+
+	errJournalMismatch = errors.New("timecard does not match the journal")
+)
+
+// Snapshot returns the marshalled form of every entry in the timecard.
+func (tc *Timecard) Snapshot() []string {
+	lines := []string{}
+	for _, e := range tc.Entries {
+		if bs, err := e.Marshal(); err == nil {
+			lines = append(lines, string(bs))
+		}
+	}
+	return lines
+}
+
+// Restore replaces the timecard's entries with a previous Snapshot.
+func (tc *Timecard) Restore(lines []string) error {
+	entries := []*Entry{}
+	for _, line := range lines {
+		e := &Entry{}
+		if err := e.Unmarshal([]byte(line)); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	tc.Entries = entries
+	tc.Header.Count = int32(len(entries))
+	return tc.Flush()
+}
+
+func sameSnapshot(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffSnapshots returns the index of the first line which differs between
+// the snapshots `a` and `b`, and the lines of each which differ from there on
+// up to their common tail.
+func diffSnapshots(a, b []string) (int, []string, []string) {
+	head := 0
+	for head < len(a) && head < len(b) && a[head] == b[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(a)-head && tail < len(b)-head && a[len(a)-1-tail] == b[len(b)-1-tail] {
+		tail++
+	}
+	return head, a[head : len(a)-tail], b[head : len(b)-tail]
+}
+
+// patch replaces the entries `from`, starting at `idx`, with `to`.  Unless
+// `force` is set, the timecard must hold exactly `from` there.
+func (tc *Timecard) patch(idx int, from, to []string, force bool) error {
+	cur := tc.Snapshot()
+	if idx > len(cur) {
+		idx = len(cur)
+	}
+	n := len(from)
+	if idx+n > len(cur) {
+		n = len(cur) - idx
+	}
+	if !force && (n != len(from) || !sameSnapshot(cur[idx:idx+n], from)) {
+		return errJournalMismatch
+	}
+	lines := append(append(append([]string{}, cur[:idx]...), to...), cur[idx+n:]...)
+	return tc.Restore(lines)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Operation is a single journaled change to a timecard: the entries from
+// Index on which it replaced, Before, and those it replaced them with, After.
+// Entries it left alone are not recorded.
+type Operation struct {
+	ID      int       `json:"id"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Index   int       `json:"index,omitempty"`
+	Before  []string  `json:"before"` // Entries before the operation
+	After   []string  `json:"after"`  // Entries after the operation
+	Undone  bool      `json:"undone,omitempty"`
+}
+
+// journalLine is a line of the journal file, either an operation or a mark
+// which undoes or redoes an earlier one.
+type journalLine struct {
+	*Operation
+	Undo int `json:"undo,omitempty"`
+	Redo int `json:"redo,omitempty"`
+}
+
+// Summary describes how many entries the operation added, removed or changed.
+func (op *Operation) Summary() string {
+	counts := map[string]int{}
+	for _, line := range op.Before {
+		counts[line]--
+	}
+	for _, line := range op.After {
+		counts[line]++
+	}
+
+	added, removed := 0, 0
+	for _, n := range counts {
+		if n > 0 {
+			added += n
+		} else {
+			removed -= n
+		}
+	}
+	changed := added
+	if removed < changed {
+		changed = removed
+	}
+	return fmt.Sprintf("+%d -%d ~%d", added-changed, removed-changed, changed)
+}
+
+// Journal records the operations performed on a timecard so that they can be
+// undone and redone.  It lives in the repository's .git directory so it is
+// never committed.  Operations, and marks undoing and redoing them, are
+// appended to the file, which is only rewritten once it holds twice as many
+// lines as the operations it keeps.
+type Journal struct {
+	Path  string
+	Ops   []*Operation
+	lines int // Lines in the file
+}
+
+// OpenJournal reads the journal stored in the directory `dir`.
+func OpenJournal(dir string) (*Journal, error) {
+	j := &Journal{Path: path.Join(dir, journalFile), Ops: []*Operation{}}
+
+	bs, err := ioutil.ReadFile(j.Path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewBuffer(bs))
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		line := &journalLine{}
+		if err := json.Unmarshal(scanner.Bytes(), line); err != nil {
+			return nil, fmt.Errorf("corrupt journal %s: %s", j.Path, err.Error())
+		}
+		j.lines++
+		switch {
+		case line.Operation != nil:
+			j.add(line.Operation)
+		case line.Undo > 0:
+			j.mark(line.Undo, true)
+		case line.Redo > 0:
+			j.mark(line.Redo, false)
+		}
+	}
+	return j, scanner.Err()
+}
+
+// add appends `op`, dropping the operations which were undone as they can no
+// longer be redone, and the oldest ones past maxJournalOps.
+func (j *Journal) add(op *Operation) {
+	ops := []*Operation{}
+	for _, o := range j.Ops {
+		if !o.Undone {
+			ops = append(ops, o)
+		}
+	}
+	ops = append(ops, op)
+	if len(ops) > maxJournalOps {
+		ops = ops[len(ops)-maxJournalOps:]
+	}
+	j.Ops = ops
+}
+
+// mark sets whether the operation `id` is undone.
+func (j *Journal) mark(id int, undone bool) {
+	for _, op := range j.Ops {
+		if op.ID == id {
+			op.Undone = undone
+		}
+	}
+}
+
+// Flush rewrites the journal file from the operations it keeps.
+func (j *Journal) Flush() error {
+	lines := []*journalLine{}
+	for _, op := range j.Ops {
+		lines = append(lines, &journalLine{Operation: op})
+	}
+	j.lines = 0
+	return j.write(lines, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// append adds `lines` to the journal file, rewriting it instead once it grew
+// too long.
+func (j *Journal) append(lines []*journalLine) error {
+	if j.lines+len(lines) > 2*len(j.Ops) && j.lines+len(lines) > maxJournalOps {
+		return j.Flush()
+	}
+	return j.write(lines, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+}
+
+func (j *Journal) write(lines []*journalLine, flags int) error {
+	if err := os.MkdirAll(path.Dir(j.Path), 0755); err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for _, line := range lines {
+		bs, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		buf.Write(bs)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(j.Path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	j.lines += len(lines)
+	return f.Close()
+}
+
+// Record journals a new operation, the change from the snapshot `before` to
+// `after`.  Operations which were undone can no longer be redone once
+// something else changed the timecard.
+func (j *Journal) Record(command string, before, after []string) error {
+	if sameSnapshot(before, after) {
+		return nil
+	}
+
+	id := 1
+	if len(j.Ops) > 0 {
+		id = j.Ops[len(j.Ops)-1].ID + 1
+	}
+	idx, from, to := diffSnapshots(before, after)
+	op := &Operation{
+		ID:      id,
+		Time:    time.Now(),
+		Command: command,
+		Index:   idx,
+		Before:  from,
+		After:   to,
+	}
+	j.add(op)
+	return j.append([]*journalLine{{Operation: op}})
+}
+
+// Undo reverts the last `n` operations which have not been undone yet.  The
+// entries an operation changed must still be as it left them, unless `force`
+// is set.
+func (j *Journal) Undo(tc *Timecard, n int, force bool) ([]*Operation, error) {
+	var err error
+	done := []*Operation{}
+	marks := []*journalLine{}
+	for i := len(j.Ops) - 1; i >= 0 && len(done) < n && err == nil; i-- {
+		op := j.Ops[i]
+		if op.Undone {
+			continue
+		}
+		if err = tc.patch(op.Index, op.After, op.Before, force); err == errJournalMismatch {
+			err = fmt.Errorf("timecard changed outside of operation %d (%s)", op.ID, op.Command)
+		} else if err == nil {
+			op.Undone = true
+			done = append(done, op)
+			marks = append(marks, &journalLine{Undo: op.ID})
+		}
+	}
+	if len(done) == 0 && err == nil {
+		return nil, ErrNothingToUndo
+	}
+	if ferr := j.append(marks); err == nil {
+		err = ferr
+	}
+	return done, err
+}
+
+// Redo re-applies the last `n` operations which were undone.
+func (j *Journal) Redo(tc *Timecard, n int, force bool) ([]*Operation, error) {
+	first := len(j.Ops)
+	for first > 0 && j.Ops[first-1].Undone {
+		first--
+	}
+
+	var err error
+	done := []*Operation{}
+	marks := []*journalLine{}
+	for i := first; i < len(j.Ops) && len(done) < n && err == nil; i++ {
+		op := j.Ops[i]
+		if err = tc.patch(op.Index, op.Before, op.After, force); err == errJournalMismatch {
+			err = fmt.Errorf("timecard changed since operation %d (%s) was undone", op.ID, op.Command)
+		} else if err == nil {
+			op.Undone = false
+			done = append(done, op)
+			marks = append(marks, &journalLine{Redo: op.ID})
+		}
+	}
+	if len(done) == 0 && err == nil {
+		return nil, ErrNothingToRedo
+	}
+	if ferr := j.append(marks); err == nil {
+		err = ferr
+	}
+	return done, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+	"syscall"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// lockFile blocks until an exclusive advisory lock on `f` is acquired.  The
+// lock is released by the kernel should the process die while holding it.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// lockFile is a no-op on windows, concurrent timecard invocations are not
+// serialized there.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
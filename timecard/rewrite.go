@@ -0,0 +1,165 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Remap re-attributes entries after history was rewritten.  `rewrites` maps
+// old commit hashes to their replacements, exactly as git feeds them to the
+// post-rewrite hook.  When several commits are squashed into one, all their
+// entries end up attributed to the new commit, so its time is the sum of the
+// squashed commits' time.  Returns the number of entries which changed.
+func (tc *Timecard) Remap(rewrites map[string]string) (int, error) {
+	n := 0
+	for _, e := range tc.Entries {
+		if e.State != cStateHashed {
+			continue
+		}
+		if h, ok := rewrites[e.Hash]; ok && h != e.Hash {
+			e.Hash = h
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, tc.Flush()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DanglingStatus describes what became of the commit of a dangling entry.
+type DanglingStatus int
+
+const (
+	DanglingRelocatable DanglingStatus = iota // Unreachable, but a copy exists
+	DanglingUnreachable DanglingStatus = iota // Unreachable, no copy was found
+	DanglingMissing     DanglingStatus = iota // Not in the object store at all
+)
+
+func (s DanglingStatus) String() string {
+	switch s {
+	case DanglingRelocatable:
+		return "relocatable"
+	case DanglingUnreachable:
+		return "unreachable"
+	case DanglingMissing:
+		return "missing"
+	}
+	return fmt.Sprintf("DanglingStatus(%d)", int(s))
+}
+
+// Dangling is an entry whose commit is not reachable from any ref.
+type Dangling struct {
+	Index  int
+	Entry  *Entry
+	Status DanglingStatus
+	Copy   string // Hash of the reachable copy for DanglingRelocatable
+	Match  string // How the copy was matched, see git.FindCopy
+}
+
+// Dangling checks every entry's commit against the repository and returns the
+// entries whose commit cannot be reached from any ref, looking for copies of
+// the commits which are still in the object store.
+func (tc *Timecard) Dangling() ([]*Dangling, error) {
+	if tc.repo == nil {
+		return nil, errors.New("checking entries requires a git repository")
+	}
+
+	reachable, err := tc.repo.Reachable()
+	if err != nil {
+		return nil, err
+	}
+
+	type found struct{ hash, match string }
+	copies := map[string]*found{}
+
+	ds := []*Dangling{}
+	for i, e := range tc.Entries {
+		if e.State != cStateHashed {
+			continue
+		}
+		if _, ok := reachable[e.Hash]; ok {
+			continue
+		}
+
+		d := &Dangling{Index: i, Entry: e, Status: DanglingMissing}
+		if tc.repo.Exists(e.Hash) {
+			if _, ok := copies[e.Hash]; !ok {
+				h, match, err := tc.repo.FindCopy(e.Hash, reachable)
+				if err != nil {
+					return nil, err
+				}
+				copies[e.Hash] = &found{h, match}
+			}
+			d.Status = DanglingUnreachable
+			if c := copies[e.Hash]; len(c.hash) > 0 {
+				d.Status, d.Copy, d.Match = DanglingRelocatable, c.hash, c.match
+			}
+		}
+		ds = append(ds, d)
+	}
+	return ds, nil
+}
+
+// RelocateCopies remaps entries whose commit is no longer reachable to a
+// cherry-picked or rebased copy of the commit, if one can be found.  Returns
+// the rewrites which were applied.
+func (tc *Timecard) RelocateCopies() (map[string]string, error) {
+	ds, err := tc.Dangling()
+	if err != nil {
+		return nil, err
+	}
+
+	rewrites := map[string]string{}
+	for _, d := range ds {
+		if d.Status == DanglingRelocatable {
+			rewrites[d.Entry.Hash] = d.Copy
+		}
+	}
+	_, err = tc.Remap(rewrites)
+	return rewrites, err
+}
+
+// Archive appends the entries at `idxs` to the file `fp` and removes them from
+// the timecard.
+func (tc *Timecard) Archive(idxs []int, fp string) error {
+	sort.Sort(sort.Reverse(sort.IntSlice(idxs)))
+
+	f, err := os.OpenFile(fp, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Write in chronological order, remove back to front.
+	for i := len(idxs) - 1; i >= 0; i-- {
+		if idxs[i] < 0 || idxs[i] >= len(tc.Entries) {
+			return ErrNoEntry
+		}
+		bs, err := tc.Entries[idxs[i]].Marshal()
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(bs, '\n')); err != nil {
+			return err
+		}
+	}
+	for i, idx := range idxs {
+		if i > 0 && idxs[i-1] == idx {
+			continue
+		}
+		tc.Entries = append(tc.Entries[:idx], tc.Entries[idx+1:]...)
+		tc.Header.Count -= 1
+	}
+	return tc.Flush()
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,190 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Rules describe what a plausible timecard entry looks like.  Entries which
+// break a rule are flagged until they are either fixed or accepted.
+type Rules struct {
+	MaxSession time.Duration  // Longest plausible entry, 0 disables the check
+	NightFrom  int            // Hour at which the overnight window opens
+	NightTo    int            // Hour at which the overnight window closes
+	AutoCap    bool           // Cap overly long entries when they are ended
+	Location   *time.Location // Timezone of the overnight window, time.Local if nil
+}
+
+// DefaultRules flags entries longer than 12 hours and entries which run
+// through the entire 02:00 - 06:00 overnight window.
+var DefaultRules = Rules{
+	MaxSession: 12 * time.Hour,
+	NightFrom:  2,
+	NightTo:    6,
+}
+
+// Violation is a single rule broken by an entry.
+type Violation int
+
+const (
+	ViolationNegative  Violation = iota // Entry ends before it starts
+	ViolationFuture    Violation = iota // Entry starts or ends in the future
+	ViolationTooLong   Violation = iota // Entry is longer than MaxSession
+	ViolationOvernight Violation = iota // Entry spans the overnight window
+)
+
+func (v Violation) String() string {
+	switch v {
+	case ViolationNegative:
+		return "negative duration"
+	case ViolationFuture:
+		return "future timestamp"
+	case ViolationTooLong:
+		return "exceeds max session length"
+	case ViolationOvernight:
+		return "runs overnight"
+	}
+	return fmt.Sprintf("Violation(%d)", int(v))
+}
+
+// location returns the timezone the overnight window is taken in.
+func (r *Rules) location() *time.Location {
+	if r.Location == nil {
+		return time.Local
+	}
+	return r.Location
+}
+
+// spansNight returns true if [start, end) fully covers the overnight window
+// of any day it touches.
+func (r *Rules) spansNight(start, end time.Time) bool {
+	if r.NightFrom == r.NightTo {
+		return false
+	}
+	loc := r.location()
+	start, end = start.In(loc), end.In(loc)
+	day := time.Date(start.Year(), start.Month(), start.Day()-1, 0, 0, 0, 0, loc)
+	for !day.After(end) {
+		from := day.Add(time.Duration(r.NightFrom) * time.Hour)
+		to := day.Add(time.Duration(r.NightTo) * time.Hour)
+		if r.NightTo < r.NightFrom {
+			to = to.AddDate(0, 0, 1)
+		}
+		if !start.After(from) && !end.Before(to) {
+			return true
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return false
+}
+
+// Check returns the rules broken by the entry `e` as of `now`.  Accepted
+// entries never violate any rules.
+func (r *Rules) Check(e *Entry, now time.Time) []Violation {
+	if e.Accepted {
+		return nil
+	}
+
+	vs := []Violation{}
+	start, end := e.StartTime(), e.EndTime(now)
+	if end.Before(start) {
+		vs = append(vs, ViolationNegative)
+	}
+	if start.After(now) || end.After(now) {
+		vs = append(vs, ViolationFuture)
+	}
+	if r.MaxSession > 0 && end.Sub(start) > r.MaxSession {
+		vs = append(vs, ViolationTooLong)
+	}
+	if r.spansNight(start, end) {
+		vs = append(vs, ViolationOvernight)
+	}
+	return vs
+}
+
+// Clamp returns the span of the entry which is considered plausible by the
+// rules, this is what reports count for entries which have not been reviewed.
+func (r *Rules) Clamp(e *Entry, now time.Time) (time.Time, time.Time) {
+	start, end := e.StartTime(), e.EndTime(now)
+	if e.Accepted {
+		return start, end
+	}
+	if start.After(now) {
+		start = now
+	}
+	if end.After(now) {
+		end = now
+	}
+	if end.Before(start) {
+		end = start
+	}
+	if r.MaxSession > 0 && end.Sub(start) > r.MaxSession {
+		end = start.Add(r.MaxSession)
+	}
+	return start, end
+}
+
+// Cap shortens the entry `e` to the plausible span returned by Clamp.
+func (r *Rules) Cap(e *Entry, now time.Time) {
+	start, end := r.Clamp(e, now)
+	e.Start, e.End = start, end
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Flagged returns the indices of all entries which break the timecard's rules.
+func (tc *Timecard) Flagged(now time.Time) []int {
+	idxs := []int{}
+	for i, e := range tc.Entries {
+		if len(tc.Rules.Check(e, now)) > 0 {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
+
+// Split breaks the entry at `idx` into two entries for the same commit, the
+// first ending at `end` and the second starting at `start`.  The time between
+// the two is dropped.  Only committed entries can be split, the halves of an
+// open entry could not both be committed later on.
+func (tc *Timecard) Split(idx int, end, start time.Time) error {
+	if idx < 0 || idx >= len(tc.Entries) {
+		return fmt.Errorf("no entry at index %d", idx)
+	}
+
+	e := tc.Entries[idx]
+	if e.State != cStateHashed {
+		return fmt.Errorf("cannot split entry %d, it is not committed yet", idx)
+	}
+	if !end.After(e.StartTime()) || start.Before(end) || !start.Before(e.EndTime(time.Time{})) {
+		return fmt.Errorf("cannot split entry %d at %s / %s", idx, end, start)
+	}
+
+	second := *e
+	second.Start = start
+	second.attrs = append([]string{}, e.attrs...)
+	second.Chain, second.Sig = "", ""
+	second.Checkpoints = nil
+	e.End = end
+	cps := e.Checkpoints
+	e.Checkpoints = nil
+	for _, cp := range cps {
+		switch {
+		case cp.Before(end):
+			e.Checkpoints = append(e.Checkpoints, cp)
+		case !cp.Before(start):
+			second.Checkpoints = append(second.Checkpoints, cp)
+		}
+	}
+	second.resetState()
+
+	tc.Entries = append(tc.Entries[:idx+1], append([]*Entry{&second}, tc.Entries[idx+1:]...)...)
+	tc.Header.Count += 1
+	return tc.Flush()
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,70 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestRulesNight(t *testing.T) {
+	// Neither zone is the machine's, so that the test does not depend on TZ.
+	tokyo := time.FixedZone("JST", 9*60*60)
+	azores := time.FixedZone("AZOT", -1*60*60)
+
+	for _, tt := range []struct {
+		name       string
+		loc        *time.Location
+		start, end time.Time
+		from, to   int
+		want       bool
+	}{
+		{"covers the window", tokyo, time.Date(2026, 10, 18, 23, 0, 0, 0, tokyo), time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo), 2, 6, true},
+		{"ends inside the window", tokyo, time.Date(2026, 10, 18, 23, 0, 0, 0, tokyo), time.Date(2026, 10, 19, 5, 0, 0, 0, tokyo), 2, 6, false},
+		{"window of another zone", azores, time.Date(2026, 10, 18, 23, 0, 0, 0, tokyo), time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo), 2, 6, false},
+		{"same span in its window", azores, time.Date(2026, 10, 19, 2, 0, 0, 0, azores), time.Date(2026, 10, 19, 6, 0, 0, 0, azores), 2, 6, true},
+		{"window across midnight", tokyo, time.Date(2026, 10, 18, 22, 0, 0, 0, tokyo), time.Date(2026, 10, 19, 2, 0, 0, 0, tokyo), 23, 1, true},
+		{"disabled", tokyo, time.Date(2026, 10, 18, 0, 0, 0, 0, tokyo), time.Date(2026, 10, 19, 0, 0, 0, 0, tokyo), 3, 3, false},
+	} {
+		r := Rules{NightFrom: tt.from, NightTo: tt.to, Location: tt.loc}
+		if got := r.spansNight(tt.start, tt.end); got != tt.want {
+			t.Errorf("%s: spansNight = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFlaggedUnzoned(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	azores := time.FixedZone("AZOT", -1*60*60)
+
+	// 23:00 to 07:00 in Tokyo, written as seconds since the epoch.
+	e := &Entry{}
+	if err := e.Unmarshal([]byte("1792332000,1792360800,a1")); err != nil {
+		t.Fatal(err)
+	}
+	if e.StartTime().Location() != unzoned {
+		t.Fatalf("legacy entry in %s, want unzoned", e.StartTime().Location())
+	}
+
+	tc := &Timecard{Header: &Header{Count: 1}, Entries: []*Entry{e}, Clock: FixedClock(testDay.Add(12 * time.Hour))}
+	for _, tt := range []struct {
+		loc  *time.Location
+		want int
+	}{{tokyo, 1}, {azores, 0}} {
+		tc.Rules = Rules{NightFrom: 2, NightTo: 6, Location: tt.loc}
+		if got := tc.Flagged(tc.Now()); len(got) != tt.want {
+			t.Errorf("flagged in %s: %v, want %d entries", tt.loc, got, tt.want)
+		}
+	}
+
+	// The same entry is in the future as of a clock before it ended.
+	tc.Rules = Rules{}
+	tc.Clock = FixedClock(time.Unix(1792350000, 0))
+	if vs := tc.Rules.Check(e, tc.Now()); len(vs) != 1 || vs[0] != ViolationFuture {
+		t.Errorf("violations as of before the end: %v", vs)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,262 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Entries are sealed into a chain: each entry's Chain is the SHA-256 of the
+// previous entry's Chain followed by the entry itself (without its seal), so
+// editing, inserting or removing an entry by hand breaks every link after it.
+// When the timecard has a Signer, each Chain is also signed with it.
+//
+// Every write also records the chain head, the last entry's Chain, in the
+// header (signed too), so that removing entries from the end of the timecard
+// does not go unnoticed either.  Putting back an earlier copy of the whole
+// file still does: the head only proves which entries were written together.
+//
+// The timecard re-seals entries it changes itself, starting from the first
+// entry which differs from the stored timecard.  It refuses to write on top of
+// a broken chain, which would otherwise launder the tampered entries.  Entries
+// signed with one of OldKeys are accepted, so that keys can be rotated.
+
+// ProblemKind describes what is wrong with a sealed entry.
+type ProblemKind int
+
+const (
+	ProblemUnsealed     ProblemKind = iota // Entry has no chain digest
+	ProblemBrokenChain  ProblemKind = iota // Digest does not match the entry
+	ProblemUnsigned     ProblemKind = iota // Entry is not signed
+	ProblemBadSignature ProblemKind = iota // Signature does not match the key
+	ProblemHead         ProblemKind = iota // Last entry is not the recorded head
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemUnsealed:
+		return "not sealed"
+	case ProblemBrokenChain:
+		return "chain broken (entry edited, inserted or removed)"
+	case ProblemUnsigned:
+		return "not signed"
+	case ProblemBadSignature:
+		return "bad signature"
+	case ProblemHead:
+		return "chain head does not match (entries removed from the end)"
+	}
+	return fmt.Sprintf("ProblemKind(%d)", int(k))
+}
+
+// Problem is a single verification failure.  Problems with the chain head are
+// reported at the index following the last entry.
+type Problem struct {
+	Index int
+	Kind  ProblemKind
+}
+
+// ErrChainBroken is returned when writing to a timecard whose stored chain
+// does not verify.
+type ErrChainBroken struct {
+	Problem *Problem
+}
+
+func (e *ErrChainBroken) Error() string {
+	return fmt.Sprintf("timecard entry @%d: %s, run \"timecard verify\"", e.Problem.Index, e.Problem.Kind)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// digest returns the chain digest of `e` following the digest `prev`.
+func digest(prev []byte, e *Entry) ([]byte, error) {
+	unsealed := *e
+	unsealed.Chain, unsealed.Sig = "", ""
+	bs, err := unsealed.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(bs)
+	return h.Sum(nil), nil
+}
+
+// headMessage returns what the signature of the chain head `head` signs, it
+// differs from the entry digests so that an entry's signature cannot pass as
+// the signature of an earlier head.
+func headMessage(head string) []byte {
+	return []byte("timecard head " + head)
+}
+
+// signedBy returns true if `sig`, base64 encoded, is a signature of `msg` by
+// one of `keys`.
+func signedBy(keys []ed25519.PublicKey, msg []byte, sig string) bool {
+	bs, err := base64.RawStdEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	for _, pub := range keys {
+		if ed25519.Verify(pub, msg, bs) {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the chain of `entries` against the head recorded in `hdr`
+// and, if `keys` are given, that one of them signed every entry and the head.
+func verify(hdr *Header, entries []*Entry, keys []ed25519.PublicKey) []*Problem {
+	problems := []*Problem{}
+	prev := make([]byte, sha256.Size)
+	for i, e := range entries {
+		d, err := digest(prev, e)
+		prev = d
+		if len(e.Chain) == 0 {
+			problems = append(problems, &Problem{i, ProblemUnsealed})
+			continue
+		}
+		if err != nil || e.Chain != hex.EncodeToString(d) {
+			problems = append(problems, &Problem{i, ProblemBrokenChain})
+			// Continue from the recorded digest so that only the
+			// tampered entry is reported, not every one after it.
+			if recorded, err := hex.DecodeString(e.Chain); err == nil {
+				prev = recorded
+			}
+			continue
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if len(e.Sig) == 0 {
+			problems = append(problems, &Problem{i, ProblemUnsigned})
+		} else if !signedBy(keys, d, e.Sig) {
+			problems = append(problems, &Problem{i, ProblemBadSignature})
+		}
+	}
+
+	// Timecards written before heads were recorded have none.
+	if hdr == nil || len(hdr.Head) == 0 {
+		return problems
+	}
+	n := len(entries)
+	switch {
+	case n == 0 || entries[n-1].Chain != hdr.Head:
+		problems = append(problems, &Problem{n, ProblemHead})
+	case len(keys) == 0:
+	case len(hdr.HeadSig) == 0:
+		problems = append(problems, &Problem{n, ProblemUnsigned})
+	case !signedBy(keys, headMessage(hdr.Head), hdr.HeadSig):
+		problems = append(problems, &Problem{n, ProblemBadSignature})
+	}
+	return problems
+}
+
+// Verify checks the timecard's chain and, if `keys` are given, that every
+// entry was signed with the private key of one of them.
+func (tc *Timecard) Verify(keys ...ed25519.PublicKey) []*Problem {
+	nonNil := []ed25519.PublicKey{}
+	for _, pub := range keys {
+		if pub != nil {
+			nonNil = append(nonNil, pub)
+		}
+	}
+	return verify(tc.Header, tc.Entries, nonNil)
+}
+
+// reseal recomputes the seal of every entry from `from` onwards, and the
+// chain head.
+func (tc *Timecard) reseal(from int) error {
+	prev := make([]byte, sha256.Size)
+	if from > 0 {
+		var err error
+		if prev, err = hex.DecodeString(tc.Entries[from-1].Chain); err != nil {
+			return err
+		}
+	}
+	for _, e := range tc.Entries[from:] {
+		d, err := digest(prev, e)
+		if err != nil {
+			return err
+		}
+		e.Chain, e.Sig = hex.EncodeToString(d), ""
+		if tc.Signer != nil {
+			e.Sig = base64.RawStdEncoding.EncodeToString(ed25519.Sign(tc.Signer, d))
+		}
+		prev = d
+	}
+
+	tc.Header.Head, tc.Header.HeadSig = "", ""
+	if n := len(tc.Entries); n > 0 {
+		tc.Header.Head = tc.Entries[n-1].Chain
+		if tc.Signer != nil {
+			tc.Header.HeadSig = base64.RawStdEncoding.EncodeToString(ed25519.Sign(tc.Signer, headMessage(tc.Header.Head)))
+		}
+	}
+	return nil
+}
+
+// sameEntry returns true if `a` and `b` only differ in their seal.
+func sameEntry(a, b *Entry) bool {
+	da, errA := digest(nil, a)
+	db, errB := digest(nil, b)
+	return errA == nil && errB == nil && string(da) == string(db)
+}
+
+// seal re-seals the entries which changed since the timecard was stored,
+// returns the index of the first entry it re-sealed.  A file store keeps what
+// it read for the write which follows.
+func (tc *Timecard) seal() (int, error) {
+	load := tc.store.Load
+	if fs, ok := tc.store.(*FileStore); ok {
+		load = fs.prepare
+	}
+	hdr, stored, err := load()
+	if err != nil {
+		hdr, stored = nil, nil // Nothing stored yet
+	}
+
+	sealed := false
+	for _, e := range stored {
+		sealed = sealed || len(e.Chain) > 0
+	}
+	if sealed {
+		var keys []ed25519.PublicKey
+		if tc.Signer != nil {
+			keys = append([]ed25519.PublicKey{tc.Signer.Public().(ed25519.PublicKey)}, tc.OldKeys...)
+		}
+		for _, p := range verify(hdr, stored, keys) {
+			if p.Kind != ProblemUnsigned {
+				return 0, &ErrChainBroken{p}
+			}
+		}
+	}
+
+	from := 0
+	for from < len(stored) && from < len(tc.Entries) && sealed && sameEntry(stored[from], tc.Entries[from]) {
+		from++
+	}
+	return from, tc.reseal(from)
+}
+
+// Reseal seals every entry anew, accepting the timecard as it is.  This is the
+// only way to write a timecard whose chain is broken.  A file store is
+// compacted, so that its header's checksum accepts the entries too.
+func (tc *Timecard) Reseal() error {
+	tc.Recount()
+	if err := tc.reseal(0); err != nil {
+		return err
+	}
+	if fs, ok := tc.store.(*FileStore); ok {
+		return fs.Compact(tc.Header, tc.Entries)
+	}
+	return tc.store.Save(tc.Header, tc.Entries)
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,188 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// histogramBounds are the upper bounds of the buckets of the commit duration
+// histogram, the last bucket is open ended.
+var histogramBounds = []time.Duration{
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	4 * time.Hour,
+	8 * time.Hour,
+}
+
+// StatsOptions describes how to compute the statistics of a timecard.
+type StatsOptions struct {
+	Location *time.Location // Timezone of entries which did not record theirs
+	Now      time.Time      // End time assumed for pending entries
+}
+
+// HistogramBucket counts the commits which took at least Min and less than
+// Max.  Max is zero for the last bucket.
+type HistogramBucket struct {
+	Min, Max time.Duration
+	Commits  int
+}
+
+// Streak is a run of consecutive days with time tracked on each of them.
+type Streak struct {
+	First, Last time.Time // First and last day, as midnight UTC
+	Days        int
+}
+
+// Stats are distributions of the time tracked in a timecard.
+type Stats struct {
+	// Time per commit, over the commits which have time tracked on them.
+	Commits   int
+	Total     time.Duration
+	Median    time.Duration
+	P90       time.Duration
+	Longest   time.Duration
+	Histogram []*HistogramBucket
+
+	// How the work on each commit was split into entries.
+	Sessions          int           // Entries with a commit
+	SessionsPerCommit float64       // Mean number of entries per commit
+	Fragmented        int           // Commits worked on over several entries
+	MedianSession     time.Duration // Median length of an entry
+
+	// When the work happened, every entry counted in its own timezone.
+	Hours         [24]time.Duration // Time tracked per hour of the day
+	Weekdays      [7]time.Duration  // Time tracked per time.Weekday
+	LongestStreak Streak
+	CurrentStreak Streak // Ending today or yesterday, zero if broken
+}
+
+// Stats computes the statistics of the timecard's entries.  Like reports,
+// entries which break the timecard's rules only count for their clamped span
+// and uncommitted entries only count towards when the work happened.
+func (tc *Timecard) Stats(opts StatsOptions) *Stats {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.Now.IsZero() {
+		opts.Now = tc.Now()
+	}
+
+	s := &Stats{}
+	perCommit := map[string]time.Duration{}
+	sessions := map[string]int{}
+	lengths := []time.Duration{}
+	days := map[time.Time]bool{}
+	for _, e := range tc.Entries {
+		start, end := tc.Rules.Clamp(e, opts.Now)
+		if !end.After(start) {
+			continue
+		}
+		if e.State == cStateHashed && len(e.Hash) > 0 {
+			perCommit[e.Hash] += end.Sub(start)
+			sessions[e.Hash]++
+			lengths = append(lengths, end.Sub(start))
+		}
+
+		// Split the entry at every hour boundary of its own timezone.
+		loc := e.Location(opts.Location)
+		for t := start.In(loc); t.Before(end); {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if next.After(end) {
+				next = end
+			}
+			s.Hours[t.Hour()] += next.Sub(t)
+			s.Weekdays[t.Weekday()] += next.Sub(t)
+			days[time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)] = true
+			t = next.In(loc)
+		}
+	}
+
+	durations := []time.Duration{}
+	for hash, d := range perCommit {
+		durations = append(durations, d)
+		s.Total += d
+		if sessions[hash] > 1 {
+			s.Fragmented++
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	s.Commits = len(durations)
+	s.Median, s.P90 = percentile(durations, 0.5), percentile(durations, 0.9)
+	if s.Commits > 0 {
+		s.Longest = durations[s.Commits-1]
+	}
+
+	min := time.Duration(0)
+	for _, max := range append(histogramBounds, 0) {
+		b := &HistogramBucket{Min: min, Max: max}
+		for _, d := range durations {
+			if d >= min && (max == 0 || d < max) {
+				b.Commits++
+			}
+		}
+		s.Histogram = append(s.Histogram, b)
+		min = max
+	}
+
+	sort.Slice(lengths, func(i, j int) bool { return lengths[i] < lengths[j] })
+	s.Sessions = len(lengths)
+	s.MedianSession = percentile(lengths, 0.5)
+	if s.Commits > 0 {
+		s.SessionsPerCommit = float64(s.Sessions) / float64(s.Commits)
+	}
+
+	s.LongestStreak, s.CurrentStreak = streaks(days, opts.Now.In(opts.Location))
+	return s
+}
+
+// percentile returns the `p` percentile of the ascending `ds`, interpolating
+// between the closest ranks.
+func percentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	rank := p * float64(len(ds)-1)
+	lo := int(math.Floor(rank))
+	if lo+1 >= len(ds) {
+		return ds[lo]
+	}
+	frac := rank - float64(lo)
+	return ds[lo] + time.Duration(frac*float64(ds[lo+1]-ds[lo]))
+}
+
+// streaks returns the longest run of consecutive `days` (UTC midnights
+// standing for calendar days) and the run which ends today or yesterday.
+func streaks(days map[time.Time]bool, now time.Time) (Streak, Streak) {
+	sorted := []time.Time{}
+	for d := range days {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	var longest, run Streak
+	for i, d := range sorted {
+		if i > 0 && d.Equal(sorted[i-1].AddDate(0, 0, 1)) {
+			run.Last, run.Days = d, run.Days+1
+		} else {
+			run = Streak{First: d, Last: d, Days: 1}
+		}
+		if run.Days > longest.Days {
+			longest = run
+		}
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if run.Days > 0 && !run.Last.Before(today.AddDate(0, 0, -1)) {
+		return longest, run
+	}
+	return longest, Streak{}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,465 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabhiram/timecard/git"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+var (
+	ErrNotLocked = errors.New("store is not locked")
+)
+
+// Store persists a timecard's header and entries.  The header passed to the
+// write methods already accounts for the change being made.
+type Store interface {
+	// Load returns the stored header and entries.
+	Load() (*Header, []*Entry, error)
+	// Append stores `e` as the new last entry.
+	Append(hdr *Header, e *Entry) error
+	// Update stores the changed entry at index `idx`.
+	Update(hdr *Header, idx int, e *Entry) error
+	// Save replaces everything in the store, used for structural changes.
+	Save(hdr *Header, entries []*Entry) error
+	// Lock acquires exclusive access to the store across processes.
+	Lock() error
+	// Unlock releases the lock taken by Lock.
+	Unlock() error
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// FileStore keeps the timecard in a plain text file.  The file holds the
+// header and the entries as of the last compaction, followed by an append-only
+// log of events, one per line:
+//
+//	~start,<index>,<entry>   a new entry was started
+//	~end,<index>,<entry>     a pending entry was ended
+//	~hash,<index>,<entry>    an ended entry was attributed to a commit
+//	~edit,<index>,<entry>    any other change to an entry
+//	~head,<count>,<head> [<headsig>]
+//	                         the chain head after the events before it
+//
+// Events carry the whole entry as it is after the change and are folded into
+// the entries on load, head events into the header.  Starting or ending an entry therefore only appends a
+// line, and the header only changes when the file is compacted, which happens
+// when an entry is inserted or removed, and once CompactAfter events piled up.
+type FileStore struct {
+	Path         string
+	LockPath     string // File locked by Lock, Path + ".lock" unless set
+	CompactAfter int    // Number of events which triggers a compaction
+	lock         *os.File
+	prepared     *fileState // Read by prepare for the next write
+}
+
+// fileState is the contents of the file as of a read, see FileStore.read.
+type fileState struct {
+	hdr     *Header
+	entries []*Entry
+	events  int
+	size    int64
+	modTime time.Time
+}
+
+const (
+	eventPrefix = "~"
+
+	eventStart = "start"
+	eventEnd   = "end"
+	eventHash  = "hash"
+	eventEdit  = "edit"
+	eventHead  = "head"
+
+	defaultCompactAfter = 1000
+)
+
+// NewFileStore returns a store backed by the file at `fp`.
+func NewFileStore(fp string) *FileStore {
+	return &FileStore{Path: fp, CompactAfter: defaultCompactAfter}
+}
+
+// NewGitStore returns a file store which lives inside the repository's .git
+// directory, keeping the timecard private to the local clone.
+func NewGitStore(r *git.Git) *FileStore {
+	return NewFileStore(path.Join(r.Dir(), "timecard", "timecard"))
+}
+
+// apply folds a single event line into the timecard's entries.
+func (tc *Timecard) apply(line string) error {
+	items := strings.SplitN(strings.TrimPrefix(line, eventPrefix), ",", 3)
+	if len(items) != 3 {
+		return errors.New("malformed event")
+	}
+	idx, err := strconv.Atoi(items[1])
+	if err != nil {
+		return err
+	}
+	if items[0] == eventHead {
+		head := append(strings.Fields(items[2]), "")
+		tc.Header.Head, tc.Header.HeadSig = head[0], head[1]
+		return nil
+	}
+	e := &Entry{}
+	if err := e.Unmarshal([]byte(items[2])); err != nil {
+		return err
+	}
+
+	switch {
+	case items[0] == eventStart && idx == len(tc.Entries):
+		tc.Entries = append(tc.Entries, e)
+		tc.Header.Count += 1
+	case items[0] != eventStart && idx >= 0 && idx < len(tc.Entries):
+		tc.Entries[idx] = e
+	default:
+		return fmt.Errorf("no entry @%d to %s", idx, items[0])
+	}
+	return nil
+}
+
+// eventKind names the change from `before` to `after`.
+func eventKind(before, after *Entry) string {
+	switch {
+	case before.State == cStatePending && after.State == cStatePartial:
+		return eventEnd
+	case before.State == cStatePartial && after.State == cStateHashed:
+		return eventHash
+	}
+	return eventEdit
+}
+
+// read returns the stored header (as of the last compaction), the folded
+// entries and the number of events.
+func (fs *FileStore) read() (*Header, []*Entry, int, error) {
+	bs, err := ioutil.ReadFile(fs.Path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	tc := &Timecard{Header: &Header{}, Entries: []*Entry{}}
+	n, err := tc.unmarshal(bs)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return tc.Header, tc.Entries, n, nil
+}
+
+func (fs *FileStore) Load() (*Header, []*Entry, error) {
+	hdr, entries, _, err := fs.read()
+	return hdr, entries, err
+}
+
+// prepare reads the file for the write which follows, so that the write does
+// not read it again.  The entries it returns are shared with that write and
+// must not be changed.
+func (fs *FileStore) prepare() (*Header, []*Entry, error) {
+	fs.prepared = nil
+	fi, err := os.Stat(fs.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	hdr, entries, n, err := fs.read()
+	if err != nil {
+		return nil, nil, err
+	}
+	fs.prepared = &fileState{hdr, entries, n, fi.Size(), fi.ModTime()}
+	return hdr, entries, nil
+}
+
+// state returns what prepare read, unless the file changed since, and reads
+// the file otherwise.
+func (fs *FileStore) state() (*Header, []*Entry, int, error) {
+	st := fs.prepared
+	fs.prepared = nil
+	if st != nil {
+		if fi, err := os.Stat(fs.Path); err == nil && fi.Size() == st.size && fi.ModTime().Equal(st.modTime) {
+			return st.hdr, st.entries, st.events, nil
+		}
+	}
+	return fs.read()
+}
+
+// appendEvents appends `lines` to the event log.
+func (fs *FileStore) appendEvents(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(fs.Path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Do not glue the first event to a hand edited last line.
+	prefix := ""
+	last := make([]byte, 1)
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		if _, err := f.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			prefix = "\n"
+		}
+	}
+	_, err = f.WriteString(prefix + strings.Join(lines, "\n") + "\n")
+	return err
+}
+
+func event(kind string, idx int, e *Entry) (string, error) {
+	bs, err := e.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s,%d,%s", eventPrefix, kind, idx, bs), nil
+}
+
+func (fs *FileStore) Append(hdr *Header, e *Entry) error {
+	was, stored, n, err := fs.state()
+	if err != nil {
+		return err
+	}
+	return fs.save(hdr, was, stored, n, append(stored[:len(stored):len(stored)], e))
+}
+
+func (fs *FileStore) Update(hdr *Header, idx int, e *Entry) error {
+	was, stored, n, err := fs.state()
+	if err != nil {
+		return err
+	}
+	if idx < 0 || idx >= len(stored) {
+		return ErrNoEntry
+	}
+	entries := append([]*Entry{}, stored...)
+	entries[idx] = e
+	return fs.save(hdr, was, stored, n, entries)
+}
+
+// Save records the differences between the stored entries and `entries` as
+// events.  Changes which events cannot express, such as removing an entry,
+// compact the file instead.
+func (fs *FileStore) Save(hdr *Header, entries []*Entry) error {
+	was, stored, n, err := fs.state()
+	if err != nil {
+		return fs.Compact(hdr, entries)
+	}
+	return fs.save(hdr, was, stored, n, entries)
+}
+
+// save appends the events turning `stored`, which already has `n` events and
+// the header `was`, into `entries`.  Events only add to the header's count, a
+// count corrected otherwise is written by compacting.
+func (fs *FileStore) save(hdr, was *Header, stored []*Entry, n int, entries []*Entry) error {
+	if len(entries) < len(stored) || int(hdr.Count) != int(was.Count)+len(entries)-len(stored) {
+		return fs.Compact(hdr, entries)
+	}
+
+	lines := []string{}
+	for i, e := range entries {
+		kind := eventStart
+		if i < len(stored) {
+			if sameLine(stored[i], e) {
+				continue
+			}
+			kind = eventKind(stored[i], e)
+		}
+		line, err := event(kind, i, e)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	if hdr.Head != was.Head || hdr.HeadSig != was.HeadSig {
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf("%s%s,%d,%s %s", eventPrefix, eventHead, len(entries), hdr.Head, hdr.HeadSig)))
+	}
+	if fs.CompactAfter > 0 && n+len(lines) > fs.CompactAfter {
+		return fs.Compact(hdr, entries)
+	}
+	return fs.appendEvents(lines)
+}
+
+// sameLine returns true if `a` and `b` marshal to the same line.
+func sameLine(a, b *Entry) bool {
+	ba, errA := a.Marshal()
+	bb, errB := b.Marshal()
+	return errA == nil && errB == nil && bytes.Equal(ba, bb)
+}
+
+// Compact rewrites the file as a header followed by `entries`, dropping the
+// event log.  The new contents are written to a temporary file next to it,
+// which then replaces the file, so that a crash never leaves a partial
+// timecard behind.
+func (fs *FileStore) Compact(hdr *Header, entries []*Entry) error {
+	fs.prepared = nil
+	tc := &Timecard{Header: hdr, Entries: entries}
+	contents, err := tc.Marshal()
+	if err != nil {
+		return err
+	}
+	contents = append(contents, '\n')
+
+	dir := filepath.Dir(fs.Path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(fs.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), fs.Path); err != nil {
+		return err
+	}
+	// Persist the rename too, where directories can be synced.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Lock takes the lock on a file next to the timecard rather than on the
+// timecard itself, which Compact replaces.
+func (fs *FileStore) Lock() error {
+	fp := fs.LockPath
+	if len(fp) == 0 {
+		fp = fs.Path + ".lock"
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fp, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return err
+	}
+	fs.lock = f
+	return nil
+}
+
+func (fs *FileStore) Unlock() error {
+	if fs.lock == nil {
+		return ErrNotLocked
+	}
+	defer func() { fs.lock = nil }()
+	if err := unlockFile(fs.lock); err != nil {
+		fs.lock.Close()
+		return err
+	}
+	return fs.lock.Close()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// MemoryStore keeps the timecard in memory, it is mostly useful for tests and
+// for embedding timecard in other tools.
+type MemoryStore struct {
+	mu      sync.Mutex
+	held    sync.Mutex // Guards locked
+	locked  bool       // Whether mu is held by Lock
+	header  Header
+	entries [][]byte // Marshalled entries, so callers cannot alias them
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (ms *MemoryStore) Load() (*Header, []*Entry, error) {
+	hdr := ms.header
+	entries := []*Entry{}
+	for _, bs := range ms.entries {
+		e := &Entry{}
+		if err := e.Unmarshal(bs); err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, e)
+	}
+	return &hdr, entries, nil
+}
+
+func (ms *MemoryStore) Append(hdr *Header, e *Entry) error {
+	bs, err := e.Marshal()
+	if err != nil {
+		return err
+	}
+	ms.header = *hdr
+	ms.entries = append(ms.entries, bs)
+	return nil
+}
+
+func (ms *MemoryStore) Update(hdr *Header, idx int, e *Entry) error {
+	if idx < 0 || idx >= len(ms.entries) {
+		return ErrNoEntry
+	}
+	bs, err := e.Marshal()
+	if err != nil {
+		return err
+	}
+	ms.header = *hdr
+	ms.entries[idx] = bs
+	return nil
+}
+
+func (ms *MemoryStore) Save(hdr *Header, entries []*Entry) error {
+	lines := [][]byte{}
+	for _, e := range entries {
+		bs, err := e.Marshal()
+		if err != nil {
+			return err
+		}
+		lines = append(lines, bs)
+	}
+	ms.header = *hdr
+	ms.entries = lines
+	return nil
+}
+
+func (ms *MemoryStore) Lock() error {
+	ms.mu.Lock()
+	ms.held.Lock()
+	ms.locked = true
+	ms.held.Unlock()
+	return nil
+}
+
+func (ms *MemoryStore) Unlock() error {
+	ms.held.Lock()
+	defer ms.held.Unlock()
+	if !ms.locked {
+		return ErrNotLocked
+	}
+	ms.locked = false
+	ms.mu.Unlock()
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
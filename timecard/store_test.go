@@ -0,0 +1,252 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// events returns the kinds of the events in the timecard file at `fp`.
+func events(t *testing.T, fp string) []string {
+	bs, err := ioutil.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if strings.HasPrefix(line, eventPrefix) {
+			kinds = append(kinds, strings.SplitN(line, ",", 2)[0])
+		}
+	}
+	return kinds
+}
+
+// reopened returns the entries of the timecard file at `fp` as loaded anew.
+func reopened(t *testing.T, fp string) []string {
+	tc, err := Open(nil, NewFileStore(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := tc.Count(); !ok {
+		t.Errorf("reopened timecard expects %d entries, has %d", n, len(tc.Entries))
+	}
+	return summary(tc.Entries)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestFileStoreEvents(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "timecard")
+	tc, err := Create(nil, NewFileStore(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		at     string
+		op     func(*Timecard) error
+		events string // Events in the file after the op
+		want   string
+	}{
+		{"09:00", (*Timecard).Start, "~start ~head", "09:00- pending"},
+		{"10:00", (*Timecard).End, "~start ~head ~end ~head", "09:00-10:00 partial"},
+		{"10:00", amended("@0", "10:30"), "~start ~head ~end ~head ~edit ~head", "09:00-10:30 partial"},
+		// Entries after an insertion are changed by events too.
+		{"11:00", added("07:00", "08:00", "a1"), "~start ~head ~end ~head ~edit ~head ~hash ~start ~head",
+			"07:00-08:00 hashed a1|09:00-10:30 partial"},
+		// Removing an entry compacts the file.
+		{"11:00", func(tc *Timecard) error { return tc.Remove(0) }, "", "09:00-10:30 partial"},
+	} {
+		tc.Clock = FixedClock(at(tt.at))
+		if err := tt.op(tc); err != nil {
+			t.Fatalf("%s: %s", tt.at, err)
+		}
+		if got := strings.Join(events(t, fp), " "); got != tt.events {
+			t.Errorf("%s: events %q, want %q", tt.at, got, tt.events)
+		}
+		if got := strings.Join(reopened(t, fp), "|"); got != tt.want {
+			t.Errorf("%s: reopened %q, want %q", tt.at, got, tt.want)
+		}
+	}
+
+	// The head events fold into the header, so the chain verifies.
+	tc, err = Open(nil, NewFileStore(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := tc.Verify(); len(problems) > 0 {
+		t.Errorf("%d problems after replaying the events, first %s", len(problems), problems[0].Kind)
+	}
+}
+
+func TestFileStoreCompactAfter(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "timecard")
+	fs := NewFileStore(fp)
+	fs.CompactAfter = 5
+	tc, err := Create(nil, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		at string
+		op func(*Timecard) error
+	}{
+		{"09:00", (*Timecard).Start},
+		{"09:10", (*Timecard).Checkpoint},
+		{"09:20", (*Timecard).Checkpoint},
+		{"09:30", (*Timecard).Checkpoint},
+		{"09:40", (*Timecard).Checkpoint},
+		{"10:00", (*Timecard).End},
+	}
+	for _, s := range steps {
+		tc.Clock = FixedClock(at(s.at))
+		if err := s.op(tc); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(events(t, fp)); n > fs.CompactAfter {
+			t.Errorf("%s: %d events, more than CompactAfter", s.at, n)
+		}
+	}
+	want := "09:00-10:00 partial @09:10 @09:20 @09:30 @09:40"
+	if got := strings.Join(reopened(t, fp), "|"); got != want {
+		t.Errorf("reopened %q, want %q", got, want)
+	}
+
+	// Compacting replaces the file through a temporary file it leaves none of.
+	if err := tc.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(events(t, fp)); n != 0 {
+		t.Errorf("%d events after compacting", n)
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != fp {
+		t.Errorf("files next to the timecard: %q", names)
+	}
+}
+
+func TestFileStorePrepared(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "timecard")
+	tc, err := Create(nil, NewFileStore(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.Clock = FixedClock(at("09:00"))
+	if err := tc.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write reads the file again if it changed since seal read it.
+	fs := NewFileStore(fp)
+	if _, _, err := fs.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	other, err := Open(nil, NewFileStore(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Clock = FixedClock(at("10:00"))
+	if err := other.End(); err != nil {
+		t.Fatal(err)
+	}
+	_, stored, _, err := fs.state()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(summary(stored), "|"); got != "09:00-10:00 partial" {
+		t.Errorf("state after a change behind its back: %q", got)
+	}
+}
+
+func TestFileStoreLegacy(t *testing.T) {
+	// A version 1 timecard: the hex encoded header of two entries, and entries
+	// in seconds since the epoch.
+	legacy := "090000010002000000\n" +
+		"1496307600,1496311200,4630753020b22159d031beeb9840aa565bc2a68b\n" +
+		"1496314800,\n"
+	fp := filepath.Join(t.TempDir(), "timecard")
+	if err := ioutil.WriteFile(fp, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := Open(nil, NewFileStore(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Header.Version != 1 || tc.Header.Count != 2 {
+		t.Errorf("legacy header: version %d, %d entries", tc.Header.Version, tc.Header.Count)
+	}
+	want := "09:00-10:00 hashed 4630753020b22159d031beeb9840aa565bc2a68b|11:00- pending"
+	if got := strings.Join(summary(tc.Entries), "|"); got != want {
+		t.Errorf("legacy entries %q, want %q", got, want)
+	}
+
+	if err := tc.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(bs), "\n")
+	if !strings.HasPrefix(lines[0], "timecard version=2 ") || !strings.Contains(lines[0], " entries=2 ") {
+		t.Errorf("rewritten header %q", lines[0])
+	}
+	// Legacy times are written back as they were, so their seals hold.
+	if !strings.HasPrefix(lines[1], "1496307600,1496311200,4630753020b22159d031beeb9840aa565bc2a68b,") ||
+		!strings.HasPrefix(lines[2], "1496314800,,") {
+		t.Errorf("rewritten entries %q", lines[1:])
+	}
+	if got := strings.Join(reopened(t, fp), "|"); got != want {
+		t.Errorf("rewritten entries %q, want %q", got, want)
+	}
+}
+
+func TestFileStoreLock(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "timecard")
+	fs := NewFileStore(fp)
+	if err := fs.Unlock(); err != ErrNotLocked {
+		t.Errorf("unlock without a lock: %v, want %v", err, ErrNotLocked)
+	}
+	if err := fs.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fp + ".lock"); err != nil {
+		t.Errorf("lock file: %s", err)
+	}
+	if err := fs.Unlock(); err != nil {
+		t.Errorf("unlock: %s", err)
+	}
+	if err := fs.Unlock(); err != ErrNotLocked {
+		t.Errorf("second unlock: %v, want %v", err, ErrNotLocked)
+	}
+}
+
+func TestMemoryStoreLock(t *testing.T) {
+	ms := NewMemoryStore()
+	if err := ms.Unlock(); err != ErrNotLocked {
+		t.Errorf("unlock without a lock: %v, want %v", err, ErrNotLocked)
+	}
+	if err := ms.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Unlock(); err != nil {
+		t.Errorf("unlock: %s", err)
+	}
+	if err := ms.Unlock(); err != ErrNotLocked {
+		t.Errorf("second unlock: %v, want %v", err, ErrNotLocked)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
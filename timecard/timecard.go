@@ -4,35 +4,110 @@ package timecard
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/ed25519"
+
 	"github.com/sabhiram/timecard/git"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
 
 const (
+	headerFormat  = "timecard" // First word of the header line
+	headerVersion = 2          // Version 1 is the legacy hex encoded header
+
 	v1HeaderSize = 9 // bytes
 )
 
+var (
+	ErrNewerFormat = errors.New("timecard was written by a newer version of timecard")
+)
+
 ////////////////////////////////////////////////////////////////////////////////
 
+// Header is the first line of the timecard file, for example:
+//
+//	timecard version=2 created=2017-06-01T09:00:00Z repo=<hash> entries=3 sha256=<hex> head=<hex> headsig=<base64>
+//
+// Unknown keys are ignored and malformed values only produce a warning, so a
+// hand edited header never prevents loading the timecard.
 type Header struct {
+	Version  uint32    // Format version of the file
+	Count    int32     // Number of timecard entries
+	Created  time.Time // When the timecard was created
+	RepoID   string    // Hash of the repository's root commit
+	Checksum string    // SHA-256 of the entries as of the last compaction
+	Head     string    // Chain digest of the last entry, see seal.go
+	HeadSig  string    // Signature of Head, if the timecard has a Signer
+}
+
+// legacyHeader is the version 1 header, stored hex encoded.
+type legacyHeader struct {
 	Size    byte   // Size of the timecard header
 	Version uint32 // 32 bit hex version 8:Major 8:Minor 16:Patch
 	Count   int32  // 32 bit count of number of timecard entries
 }
 
 func (h *Header) Unmarshal(data []byte) error {
-	decoded, err := hex.DecodeString(string(data))
+	line := strings.TrimSpace(string(data))
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != headerFormat {
+		return h.unmarshalLegacy(line)
+	}
+
+	*h = Header{Version: headerVersion}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			log.Printf("Warning: ignoring timecard header field %q.\n", field)
+			continue
+		}
+
+		var err error
+		switch kv[0] {
+		case "version":
+			var v uint64
+			if v, err = strconv.ParseUint(kv[1], 10, 32); err == nil {
+				h.Version = uint32(v)
+			}
+		case "created":
+			h.Created, err = time.Parse(time.RFC3339, kv[1])
+		case "repo":
+			h.RepoID = kv[1]
+		case "entries":
+			var n int64
+			if n, err = strconv.ParseInt(kv[1], 10, 32); err == nil {
+				h.Count = int32(n)
+			}
+		case "sha256":
+			h.Checksum = kv[1]
+		case "head":
+			h.Head = kv[1]
+		case "headsig":
+			h.HeadSig = kv[1]
+		}
+		if err != nil {
+			log.Printf("Warning: ignoring timecard header field %q.\n", field)
+		}
+	}
+	if h.Version > headerVersion {
+		return ErrNewerFormat
+	}
+	return nil
+}
+
+func (h *Header) unmarshalLegacy(line string) error {
+	decoded, err := hex.DecodeString(line)
 	if err != nil {
 		return err
 	}
@@ -40,15 +115,41 @@ func (h *Header) Unmarshal(data []byte) error {
 	if len(decoded) < v1HeaderSize {
 		return errors.New("insufficient data, cannot form header")
 	}
-	return binary.Read(bytes.NewBuffer(decoded), binary.LittleEndian, h)
+	legacy := legacyHeader{}
+	if err := binary.Read(bytes.NewBuffer(decoded), binary.LittleEndian, &legacy); err != nil {
+		return err
+	}
+	*h = Header{Version: 1, Count: legacy.Count}
+	return nil
 }
 
+// Marshal always writes the current header format, upgrading legacy headers.
 func (h *Header) Marshal() ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 0, 1+4+4))
-	binary.Write(buf, binary.LittleEndian, h.Size)
-	binary.Write(buf, binary.LittleEndian, h.Version)
-	binary.Write(buf, binary.LittleEndian, h.Count)
-	return []byte(hex.EncodeToString(buf.Bytes())), nil
+	h.Version = headerVersion
+	fields := []string{headerFormat, fmt.Sprintf("version=%d", h.Version)}
+	if !h.Created.IsZero() {
+		fields = append(fields, "created="+h.Created.UTC().Format(time.RFC3339))
+	}
+	if len(h.RepoID) > 0 {
+		fields = append(fields, "repo="+h.RepoID)
+	}
+	fields = append(fields, fmt.Sprintf("entries=%d", h.Count))
+	if len(h.Checksum) > 0 {
+		fields = append(fields, "sha256="+h.Checksum)
+	}
+	if len(h.Head) > 0 {
+		fields = append(fields, "head="+h.Head)
+	}
+	if len(h.HeadSig) > 0 {
+		fields = append(fields, "headsig="+h.HeadSig)
+	}
+	return []byte(strings.Join(fields, " ")), nil
+}
+
+// checksum returns the SHA-256 of the entry lines `lines`.
+func checksum(lines []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -60,13 +161,49 @@ const (
 	cStateHashed  = iota // Hash has been recorded
 )
 
+// unzoned is the location of times read from entries written before times were
+// recorded with their UTC offset, as whole seconds since the epoch.  Such times
+// are written back in the same form so that their seals remain valid.
+var unzoned = time.FixedZone("unzoned", 0)
+
+// parseTime reads an entry time, either RFC 3339 with an optional fraction of
+// a second or the legacy seconds since the epoch.
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).In(unzoned), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// formatTime writes an entry time with the UTC offset it was recorded in.
+func formatTime(t time.Time) string {
+	if t.Location() == unzoned {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
 // Entry represents a single entry in a timecard.  NOTE: We are currently
 // ignoring checkpoints in the entries.
+//
+// Entries are serialized as "start,end,hash" optionally followed by any number
+// of "key=value" attributes, pending entries leave the end (and hash) empty.
+// Times are RFC 3339 with nanoseconds and the UTC offset at which they were
+// recorded, e.g. "2017-06-01T09:00:00.123456789+02:00".
 type Entry struct {
-	Start int64 // Seconds since epoch
-	End   int64 // Seconds since epoch
-	Hash  string
-	State int
+	Start    time.Time // Zero for invalid entries
+	End      time.Time // Zero until the entry is ended
+	Hash     string
+	State    int
+	Accepted bool   // Entry was reviewed and accepted despite breaking rules
+	Note     string // Free-form note attached to the entry
+
+	Checkpoints []time.Time // Checkpoints recorded within the entry
+
+	Chain string // Digest chaining the entry to the previous one, see seal.go
+	Sig   string // Optional ed25519 signature of Chain
+
+	attrs []string // Unrecognized "key=value" attributes, preserved as-is
 }
 
 // Unmarshal takes a single line of timecard input and attempts to convert
@@ -77,52 +214,155 @@ func (e *Entry) Unmarshal(data []byte) error {
 		return errors.New("cannot make entry from empty line")
 	}
 
-	e.Start = 0
-	e.End = 0
-	e.Hash = ""
-	e.State = cStateUnknown
+	*e = Entry{State: cStateUnknown}
 
 	items := strings.Split(line, ",")
-	switch len(items) {
-	case 1:
+	if len(items) < 2 {
 		return errors.New("invalid timecard line detected")
-	case 2:
-		start, err := strconv.ParseInt(items[0], 10, 64)
-		if err != nil {
-			return errors.New("unable to parse start time")
-		}
-		e.Start = start
-		e.State = cStatePending
-		return nil
-	case 3:
-		start, err := strconv.ParseInt(items[0], 10, 64)
-		if err != nil {
-			return errors.New("unable to parse start time")
+	}
+
+	start, err := parseTime(items[0])
+	if err != nil {
+		return errors.New("unable to parse start time")
+	}
+	e.Start = start
+	e.State = cStatePending
+	if len(items) == 2 || len(items[1]) == 0 {
+		return e.unmarshalAttrs(items[2:])
+	}
+
+	end, err := parseTime(items[1])
+	if err != nil {
+		return errors.New("unable to parse end time")
+	}
+	e.End = end
+	e.Hash = items[2]
+	e.State = cStateHashed
+	if len(e.Hash) == 0 {
+		e.State = cStatePartial
+	}
+	return e.unmarshalAttrs(items[3:])
+}
+
+// unmarshalAttrs parses the trailing "key=value" attributes of an entry.
+func (e *Entry) unmarshalAttrs(items []string) error {
+	for _, item := range items {
+		if len(item) == 0 {
+			continue
 		}
-		end, err := strconv.ParseInt(items[1], 10, 64)
-		if err != nil {
-			return errors.New("unable to parse end time")
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid entry attribute %q", item)
 		}
-		e.Start = start
-		e.End = end
-		e.Hash = items[2]
-		e.State = cStateHashed
-		if len(e.Hash) == 0 {
-			e.State = cStatePartial
+		switch kv[0] {
+		case "accepted":
+			e.Accepted = kv[1] == "1"
+		case "note":
+			note, err := url.QueryUnescape(kv[1])
+			if err != nil {
+				return fmt.Errorf("invalid entry note %q", kv[1])
+			}
+			e.Note = note
+		case "chain":
+			e.Chain = kv[1]
+		case "sig":
+			e.Sig = kv[1]
+		case "checkpoints":
+			for _, cp := range strings.Split(kv[1], ";") {
+				t, err := parseTime(cp)
+				if err != nil {
+					return fmt.Errorf("invalid entry checkpoint %q", cp)
+				}
+				e.Checkpoints = append(e.Checkpoints, t)
+			}
+		default:
+			e.attrs = append(e.attrs, item)
 		}
-		return nil
 	}
-	return errors.New("invalid timecard line detected")
+	return nil
+}
+
+// StartTime returns the start of the entry.
+func (e *Entry) StartTime() time.Time {
+	return e.Start
+}
+
+// EndTime returns the end of the entry, entries which are still pending are
+// considered to run until `now`.
+func (e *Entry) EndTime(now time.Time) time.Time {
+	if e.State == cStatePending || e.End.IsZero() {
+		return now
+	}
+	return e.End
+}
+
+// Location returns the timezone the entry was recorded in, or `fallback` for
+// entries written before timezones were recorded.
+func (e *Entry) Location(fallback *time.Location) *time.Location {
+	if loc := e.Start.Location(); loc != unzoned && !e.Start.IsZero() {
+		return loc
+	}
+	return fallback
+}
+
+// Duration returns the time spent on the entry as of `now`.
+func (e *Entry) Duration(now time.Time) time.Duration {
+	return e.EndTime(now).Sub(e.StartTime())
+}
+
+// StateName returns the entry's state as "pending" (started), "partial"
+// (ended, waiting for a commit), "hashed" or "unknown".
+func (e *Entry) StateName() string {
+	switch e.State {
+	case cStatePending:
+		return "pending"
+	case cStatePartial:
+		return "partial"
+	case cStateHashed:
+		return "hashed"
+	}
+	return "unknown"
 }
 
 func (e *Entry) Marshal() ([]byte, error) {
-	if e == nil || e.Start == 0 {
+	if e == nil || e.Start.IsZero() {
 		return nil, errors.New("invalid timecard entry")
 	}
-	if e.End == 0 {
-		return []byte(fmt.Sprintf("%d,", e.Start)), nil
+
+	attrs := []string{}
+	if e.Accepted {
+		attrs = append(attrs, "accepted=1")
+	}
+	if len(e.Note) > 0 {
+		attrs = append(attrs, "note="+url.QueryEscape(e.Note))
+	}
+	if len(e.Checkpoints) > 0 {
+		cps := []string{}
+		for _, t := range e.Checkpoints {
+			cps = append(cps, formatTime(t))
+		}
+		attrs = append(attrs, "checkpoints="+strings.Join(cps, ";"))
+	}
+	attrs = append(attrs, e.attrs...)
+	if len(e.Chain) > 0 {
+		attrs = append(attrs, "chain="+e.Chain)
+	}
+	if len(e.Sig) > 0 {
+		attrs = append(attrs, "sig="+e.Sig)
+	}
+
+	if e.End.IsZero() {
+		if len(attrs) == 0 {
+			return []byte(fmt.Sprintf("%s,", formatTime(e.Start))), nil
+		}
+		return []byte(fmt.Sprintf("%s,,,%s", formatTime(e.Start), strings.Join(attrs, ","))), nil
+	}
+
+	line := fmt.Sprintf("%s,%s,%s", formatTime(e.Start), formatTime(e.End), e.Hash)
+	if len(attrs) > 0 {
+		line += "," + strings.Join(attrs, ",")
 	}
-	return []byte(fmt.Sprintf("%d,%d,%s", e.Start, e.End, e.Hash)), nil
+	return []byte(line), nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -131,124 +371,285 @@ func (e *Entry) Marshal() ([]byte, error) {
 // specified in the structure can be used as a hint to migrate the header block
 // should the below structure ever have to change.
 type Timecard struct {
-	Path    string   // path to file
-	Header  *Header  // Timecard's header
-	Entries []*Entry // Slice of timecard entries
+	Header  *Header             // Timecard's header
+	Entries []*Entry            // Slice of timecard entries
+	Rules   Rules               // Sanity rules applied to entries
+	Signer  ed25519.PrivateKey  // Optional key signing the entries' chain
+	OldKeys []ed25519.PublicKey // Keys which signed entries before Signer
+	Clock   Clock               // Time source, SystemClock unless set
 	repo    *git.Git
+	store   Store
 }
 
-func Init(r *git.Git, fp string) (*Timecard, error) {
+// Create initializes a new, empty timecard in the store `s`.
+func Create(r *git.Git, s Store) (*Timecard, error) {
 	tc := &Timecard{
-		Path: fp,
 		Header: &Header{
-			Size:    v1HeaderSize, // Fixed v1 header size
-			Version: 0x00000001,   // 0.0.0001
-			Count:   0,            // no entries as of now
+			Version: headerVersion,
+			Count:   0, // no entries as of now
+			Created: time.Now().UTC(),
 		},
 		Entries: []*Entry{},
+		Rules:   DefaultRules,
+		Clock:   SystemClock,
 		repo:    r,
+		store:   s,
+	}
+	if r != nil {
+		tc.Header.RepoID, _ = r.RootCommit() // Empty until the first commit
 	}
 	return tc, tc.Flush()
 }
 
+// Open loads an existing timecard from the store `s`.
+func Open(r *git.Git, s Store) (*Timecard, error) {
+	tc := &Timecard{
+		Rules: DefaultRules,
+		Clock: SystemClock,
+		repo:  r,
+		store: s,
+	}
+	return tc, tc.reload()
+}
+
+// Init creates a new timecard file at `fp`.
+func Init(r *git.Git, fp string) (*Timecard, error) {
+	return Create(r, NewFileStore(fp))
+}
+
+// Load reads the timecard file at `fp`.
 func Load(r *git.Git, fp string) (*Timecard, error) {
-	bs, err := ioutil.ReadFile(fp)
+	return Open(r, NewFileStore(fp))
+}
+
+// reload replaces the in-memory header and entries with the stored ones.
+func (tc *Timecard) reload() error {
+	hdr, entries, err := tc.store.Load()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	tc.Header, tc.Entries = hdr, entries
+	return nil
+}
 
-	tc := &Timecard{
-		Path:    fp,
-		Header:  &Header{},
-		Entries: []*Entry{},
-		repo:    r,
+// Lock acquires the store's lock and reloads the timecard, so that changes
+// made by other processes in the meantime are not lost.
+func (tc *Timecard) Lock() error {
+	if err := tc.store.Lock(); err != nil {
+		return err
 	}
-	return tc, tc.Unmarshal(bs)
+	if err := tc.reload(); err != nil {
+		tc.store.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unlock releases the store's lock.
+func (tc *Timecard) Unlock() error {
+	return tc.store.Unlock()
+}
+
+// Repo returns the git repository the timecard belongs to.
+func (tc *Timecard) Repo() *git.Git {
+	return tc.repo
 }
 
 // Unmarshal converts a file blob into a timecard instance `tc`.
 func (tc *Timecard) Unmarshal(blob []byte) error {
+	_, err := tc.unmarshal(blob)
+	return err
+}
+
+// unmarshal reads the compacted entries which follow the header and folds the
+// events appended after them (see store.go) into the entries.  Returns the
+// number of events.
+func (tc *Timecard) unmarshal(blob []byte) (int, error) {
+	if tc.Header == nil {
+		tc.Header = &Header{}
+	}
+
 	lines := strings.Split(string(blob), "\n")
 	if len(lines) == 0 {
-		return errors.New("empty .timecard file cannot be read")
+		return 0, errors.New("empty .timecard file cannot be read")
 	}
 
-	hdr, lines := []byte(lines[0]), lines[1:]
-	if err := tc.Header.Unmarshal(hdr); err != nil {
-		return err
+	headerless := false
+	if err := tc.Header.Unmarshal([]byte(lines[0])); err == ErrNewerFormat {
+		return 0, err
+	} else if err != nil {
+		headerless = true
+		// Keep going without a header rather than refusing to load, the
+		// first line may well be an entry.
+		log.Printf("Warning: unreadable timecard header %q, ignoring it.\n", lines[0])
+		*tc.Header = Header{Version: headerVersion}
+		if (&Entry{}).Unmarshal([]byte(lines[0])) != nil {
+			lines = lines[1:]
+		}
+	} else {
+		lines = lines[1:]
 	}
 
+	events, body := []string{}, []string{}
 	for _, line := range lines {
+		if strings.HasPrefix(line, eventPrefix) {
+			events = append(events, line)
+			continue
+		}
+		if len(line) > 0 {
+			body = append(body, line)
+		}
 		e := &Entry{}
 		if err := e.Unmarshal([]byte(line)); err == nil {
 			tc.Entries = append(tc.Entries, e)
 		}
 	}
-	return nil
+
+	if headerless {
+		tc.Header.Count = int32(len(tc.Entries))
+	}
+	if len(tc.Header.Checksum) > 0 && tc.Header.Checksum != checksum(body) {
+		log.Printf("Warning: timecard entries do not match the header's checksum, they were edited by hand.\n")
+	}
+
+	for _, line := range events {
+		if err := tc.apply(line); err != nil {
+			log.Printf("Warning: ignoring timecard event %q: %s\n", line, err.Error())
+		}
+	}
+
+	// A count which does not match the entries points at lost or hand edited
+	// lines, it is kept until "timecard verify --reseal" or "timecard gc"
+	// accept the entries as they are.
+	if int(tc.Header.Count) != len(tc.Entries) {
+		log.Printf("Warning: timecard header expects %d entries, found %d, run \"timecard verify\".\n", tc.Header.Count, len(tc.Entries))
+	}
+	return len(events), nil
 }
 
 // Marshal converts the timecard into a string.
 func (tc *Timecard) Marshal() ([]byte, error) {
-	hdr, err := tc.Header.Marshal()
-	if err != nil {
-		return nil, err
-	}
-
-	result := []string{string(hdr)}
+	lines := []string{}
 	for _, entry := range tc.Entries {
 		bs, err := entry.Marshal()
 		if err != nil {
 			log.Printf("Warning: Bad line: %s. Ignoring.\n", string(bs))
 		} else {
-			result = append(result, string(bs))
+			lines = append(lines, string(bs))
 		}
 	}
-	return []byte(strings.Join(result, "\n")), nil
+
+	tc.Header.Checksum = checksum(lines)
+	hdr, err := tc.Header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(append([]string{string(hdr)}, lines...), "\n")), nil
 }
 
-// Flush updates the timecard instance `tc` to it's specified path.
+// Flush writes the whole timecard instance `tc` to its store.
 func (tc *Timecard) Flush() error {
-	contents, err := tc.Marshal()
+	if _, err := tc.seal(); err != nil {
+		return err
+	}
+	return tc.store.Save(tc.Header, tc.Entries)
+}
+
+// Compact folds the store's event log into its entries, if it keeps one.
+func (tc *Timecard) Compact() error {
+	if fs, ok := tc.store.(*FileStore); ok {
+		if _, err := tc.seal(); err != nil {
+			return err
+		}
+		if len(tc.Header.RepoID) == 0 && tc.repo != nil {
+			tc.Header.RepoID, _ = tc.repo.RootCommit()
+		}
+		return fs.Compact(tc.Header, tc.Entries)
+	}
+	return nil
+}
+
+// append persists the entry `e` which was just appended to the timecard.
+func (tc *Timecard) append(e *Entry) error {
+	from, err := tc.seal()
 	if err != nil {
 		return err
 	}
-	contents = append(contents, '\n')
-	if err := ioutil.WriteFile(tc.Path, contents, 0755); err != nil {
+	if from < len(tc.Entries)-1 {
+		return tc.store.Save(tc.Header, tc.Entries)
+	}
+	return tc.store.Append(tc.Header, e)
+}
+
+// update persists the change made to the entry at `idx`.
+func (tc *Timecard) update(idx int) error {
+	from, err := tc.seal()
+	if err != nil {
 		return err
 	}
+	if from < idx {
+		return tc.store.Save(tc.Header, tc.Entries)
+	}
+	return tc.store.Update(tc.Header, idx, tc.Entries[idx])
+}
 
+// checkCount returns an error if the header's entry count does not match the
+// entries, the current entry cannot be told apart then.
+func (tc *Timecard) checkCount() error {
+	if int(tc.Header.Count) != len(tc.Entries) {
+		return fmt.Errorf("timecard header expects %d entries, found %d: check them with \"timecard verify\" "+
+			"and accept them with \"timecard verify --reseal\"", tc.Header.Count, len(tc.Entries))
+	}
 	return nil
 }
 
+// Count returns the number of entries the header expects and whether the
+// timecard has that many.
+func (tc *Timecard) Count() (int, bool) {
+	return int(tc.Header.Count), int(tc.Header.Count) == len(tc.Entries)
+}
+
+// Recount accepts the entries as they are, setting the header's count to the
+// number of entries.  Returns the previous count.
+func (tc *Timecard) Recount() int {
+	old := int(tc.Header.Count)
+	tc.Header.Count = int32(len(tc.Entries))
+	return old
+}
+
 // Start starts or re-starts the current entry. This includes figuring out the
 // current commit hash so it can be attributed to the last commit.
 func (tc *Timecard) Start() error {
-	if int(tc.Header.Count) != len(tc.Entries) {
-		panic("header count and entry length mismatch")
+	if err := tc.checkCount(); err != nil {
+		return err
 	}
 
-	appendNewEntryFn := func(tc *Timecard, t int64) error {
-		tc.Header.Count += 1
-		tc.Entries = append(tc.Entries, &Entry{
+	appendNewEntryFn := func(tc *Timecard, t time.Time) error {
+		e := &Entry{
 			Start: t,
 			State: cStatePending,
-		})
-		return tc.Flush()
+		}
+		tc.Header.Count += 1
+		tc.Entries = append(tc.Entries, e)
+		return tc.append(e)
 	}
 
 	// If we have no entries, we make a new one with just a start time.
 	if tc.Header.Count == 0 {
-		return appendNewEntryFn(tc, time.Now().Unix())
+		return appendNewEntryFn(tc, tc.Now())
 	}
 
 	// Grab the last entry, if it is complete - make a new one.  If it is
 	// pending - update the current one's start time.
 	lastIdx := tc.Header.Count - 1
+	if last := tc.Entries[lastIdx]; last.State != cStatePending && tc.Now().Before(last.End) {
+		return errors.New("timecard entry cannot start before the previous one ended")
+	}
 	switch tc.Entries[lastIdx].State {
 	case cStatePending:
 		// Pending entries should just be updated with a new start time.
-		tc.Entries[lastIdx].Start = time.Now().Unix()
-		return tc.Flush()
+		tc.Entries[lastIdx].Start = tc.Now()
+		return tc.update(int(lastIdx))
 	case cStatePartial:
 		// Latest entry is partial, figure out the right commit hash for it
 		// and make a new entry.
@@ -258,18 +659,21 @@ func (tc *Timecard) Start() error {
 		}
 		tc.Entries[lastIdx].Hash = headHash
 		tc.Entries[lastIdx].State = cStateHashed
-		return appendNewEntryFn(tc, time.Now().Unix())
+		if err := tc.update(int(lastIdx)); err != nil {
+			return err
+		}
+		return appendNewEntryFn(tc, tc.Now())
 	case cStateHashed:
 		// Latest entry is recorded, make a new entry.
-		return appendNewEntryFn(tc, time.Now().Unix())
+		return appendNewEntryFn(tc, tc.Now())
 	}
 	return nil
 }
 
 // End closes a timecard entry.
 func (tc *Timecard) End() error {
-	if int(tc.Header.Count) != len(tc.Entries) {
-		panic("header count and entry length mismatch")
+	if err := tc.checkCount(); err != nil {
+		return err
 	}
 
 	// If we have no entries, throw an error.
@@ -278,17 +682,46 @@ func (tc *Timecard) End() error {
 	}
 
 	lastIdx := tc.Header.Count - 1
-	log.Printf("Last entry: %#v\n", tc.Entries[lastIdx])
 	switch tc.Entries[lastIdx].State {
 	case cStatePending:
 		// Pending entries get promoted to partial
-		tc.Entries[lastIdx].End = time.Now().Unix()
-		tc.Entries[lastIdx].State = cStatePartial
-		return tc.Flush()
+		now := tc.Now()
+		e := tc.Entries[lastIdx]
+		if now.Before(e.Start) {
+			return errors.New("timecard entry cannot end before it started")
+		}
+		e.End = now
+		e.State = cStatePartial
+		for _, v := range tc.Rules.Check(e, now) {
+			log.Printf("Warning: timecard entry %s, run \"timecard review\" to fix it.\n", v)
+		}
+		if tc.Rules.AutoCap {
+			tc.Rules.Cap(e, now)
+		}
+		return tc.update(int(lastIdx))
 	case cStatePartial:
 		return errors.New("timecard entry already closed")
 	}
 	return errors.New("mismatched \"timecard end\" without \"timecard start\"")
 }
 
+// Checkpoint records the current time within the open timecard entry.
+func (tc *Timecard) Checkpoint() error {
+	if err := tc.checkCount(); err != nil {
+		return err
+	}
+
+	lastIdx := int(tc.Header.Count) - 1
+	if lastIdx < 0 || tc.Entries[lastIdx].State != cStatePending {
+		return errors.New("\"timecard checkpoint\" requires a started entry")
+	}
+	e := tc.Entries[lastIdx]
+	now := tc.Now()
+	if now.Before(e.Start) {
+		return errors.New("checkpoint cannot be before the entry started")
+	}
+	e.Checkpoints = append(e.Checkpoints, now)
+	return tc.update(lastIdx)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,451 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+var testDay = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+// at returns the time "HH:MM" on the test day.
+func at(hhmm string) time.Time {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		panic(err)
+	}
+	return testDay.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute)
+}
+
+// added returns an op adding an entry for `hash` from `start` to `end`.
+func added(start, end, hash string) func(*Timecard) error {
+	return func(tc *Timecard) error {
+		_, err := tc.Add(&Entry{Start: at(start), End: at(end), Hash: hash})
+		return err
+	}
+}
+
+// amended returns an op moving the end of the entry selected by `sel`.  The
+// entry is put back if the change is refused, "timecard amend" drops the
+// whole timecard then.
+func amended(sel, end string) func(*Timecard) error {
+	return func(tc *Timecard) error {
+		idx, err := tc.Find(sel)
+		if err != nil {
+			return err
+		}
+		was := *tc.Entries[idx]
+		tc.Entries[idx].End = at(end)
+		if err := tc.Update(idx); err != nil {
+			*tc.Entries[idx] = was
+			return err
+		}
+		return nil
+	}
+}
+
+// split returns an op splitting the entry at `idx`.
+func split(idx int, end, start string) func(*Timecard) error {
+	return func(tc *Timecard) error {
+		return tc.Split(idx, at(end), at(start))
+	}
+}
+
+// summary renders the entries as "HH:MM-HH:MM state hash [checkpoints]".
+func summary(es []*Entry) []string {
+	states := map[int]string{cStatePending: "pending", cStatePartial: "partial", cStateHashed: "hashed"}
+	lines := []string{}
+	for _, e := range es {
+		end := ""
+		if !e.End.IsZero() {
+			end = e.End.UTC().Format("15:04")
+		}
+		line := fmt.Sprintf("%s-%s %s", e.Start.UTC().Format("15:04"), end, states[e.State])
+		if len(e.Hash) > 0 {
+			line += " " + e.Hash
+		}
+		for _, cp := range e.Checkpoints {
+			line += " @" + cp.UTC().Format("15:04")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestEntries(t *testing.T) {
+	type step struct {
+		at  string // Time on the clock when the step runs
+		op  func(*Timecard) error
+		err string // Expected error, empty if none
+	}
+
+	for _, tt := range []struct {
+		name  string
+		steps []step
+		want  []string
+	}{
+		{
+			name: "start and end",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).Start},
+				{at: "10:30", op: (*Timecard).End},
+			},
+			want: []string{"09:00-10:30 partial"},
+		},
+		{
+			name: "restart a pending entry",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).Start},
+				{at: "09:30", op: (*Timecard).Start},
+			},
+			want: []string{"09:30- pending"},
+		},
+		{
+			name: "checkpoints",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).Start},
+				{at: "09:20", op: (*Timecard).Checkpoint},
+				{at: "09:40", op: (*Timecard).Checkpoint},
+				{at: "10:00", op: (*Timecard).End},
+			},
+			want: []string{"09:00-10:00 partial @09:20 @09:40"},
+		},
+		{
+			name: "end before start",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).Start},
+				{at: "08:00", op: (*Timecard).End, err: "cannot end before it started"},
+			},
+			want: []string{"09:00- pending"},
+		},
+		{
+			name: "end without start",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).End, err: "without \"timecard start\""},
+			},
+			want: []string{},
+		},
+		{
+			name: "end twice",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).Start},
+				{at: "10:00", op: (*Timecard).End},
+				{at: "10:10", op: (*Timecard).End, err: "already closed"},
+			},
+			want: []string{"09:00-10:00 partial"},
+		},
+		{
+			name: "checkpoint without start",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).Checkpoint, err: "requires a started entry"},
+			},
+			want: []string{},
+		},
+		{
+			name: "checkpoint before start",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).Start},
+				{at: "08:59", op: (*Timecard).Checkpoint, err: "before the entry started"},
+			},
+			want: []string{"09:00- pending"},
+		},
+		{
+			name: "add in chronological order",
+			steps: []step{
+				{at: "12:00", op: added("10:00", "11:00", "b2")},
+				{at: "12:00", op: added("07:00", "08:00", "a1")},
+			},
+			want: []string{"07:00-08:00 hashed a1", "10:00-11:00 hashed b2"},
+		},
+		{
+			name: "add before the open entry",
+			steps: []step{
+				{at: "09:00", op: (*Timecard).Start},
+				{at: "09:10", op: added("10:00", "11:00", "a1")},
+			},
+			want: []string{"10:00-11:00 hashed a1", "09:00- pending"},
+		},
+		{
+			name: "add ending before it starts",
+			steps: []step{
+				{at: "12:00", op: added("11:00", "10:00", "a1"), err: "before it starts"},
+			},
+			want: []string{},
+		},
+		{
+			name: "add without a hash",
+			steps: []step{
+				{at: "12:00", op: added("10:00", "11:00", ""), err: "commit hash"},
+			},
+			want: []string{},
+		},
+		{
+			name: "amend",
+			steps: []step{
+				{at: "12:00", op: added("10:00", "11:00", "a1")},
+				{at: "12:00", op: amended("a1", "11:30")},
+			},
+			want: []string{"10:00-11:30 hashed a1"},
+		},
+		{
+			name: "amend to end before it starts",
+			steps: []step{
+				{at: "12:00", op: added("10:00", "11:00", "a1")},
+				{at: "12:00", op: amended("@0", "09:00"), err: "before it starts"},
+			},
+			want: []string{"10:00-11:00 hashed a1"},
+		},
+		{
+			name: "amend a commit with several entries",
+			steps: []step{
+				{at: "12:00", op: added("08:00", "09:00", "a1")},
+				{at: "12:00", op: added("10:00", "11:00", "a1")},
+				{at: "12:00", op: amended("a1", "11:30"), err: "(@0, @1), select one of them by index"},
+				{at: "12:00", op: amended("@1", "11:30")},
+			},
+			want: []string{"08:00-09:00 hashed a1", "10:00-11:30 hashed a1"},
+		},
+		{
+			name: "split",
+			steps: []step{
+				{at: "13:00", op: added("08:00", "12:00", "a1")},
+				{at: "13:00", op: split(0, "09:00", "10:30")},
+			},
+			want: []string{"08:00-09:00 hashed a1", "10:30-12:00 hashed a1"},
+		},
+		{
+			name: "split outside the entry",
+			steps: []step{
+				{at: "13:00", op: added("08:00", "12:00", "a1")},
+				{at: "13:00", op: split(0, "07:00", "10:30"), err: "cannot split"},
+				{at: "13:00", op: split(0, "10:00", "09:00"), err: "cannot split"},
+				{at: "13:00", op: split(1, "09:00", "10:00"), err: "no entry"},
+			},
+			want: []string{"08:00-12:00 hashed a1"},
+		},
+		{
+			name: "split an uncommitted entry",
+			steps: []step{
+				{at: "08:00", op: (*Timecard).Start},
+				{at: "12:00", op: (*Timecard).End},
+				{at: "13:00", op: split(0, "09:00", "10:00"), err: "not committed"},
+			},
+			want: []string{"08:00-12:00 partial"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			tc, err := Create(nil, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.steps {
+				tc.Clock = FixedClock(at(s.at))
+				err := s.op(tc)
+				switch {
+				case len(s.err) == 0 && err != nil:
+					t.Fatalf("step %d: unexpected error: %s", i, err)
+				case len(s.err) > 0 && err == nil:
+					t.Fatalf("step %d: expected an error containing %q", i, s.err)
+				case len(s.err) > 0 && !strings.Contains(err.Error(), s.err):
+					t.Fatalf("step %d: got error %q, want one containing %q", i, err, s.err)
+				}
+			}
+
+			if got := summary(tc.Entries); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("entries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+
+			// What was stored must read back the same.
+			reopened, err := Open(nil, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := summary(reopened.Entries); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("stored entries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if problems := reopened.Verify(nil); len(problems) > 0 {
+				t.Errorf("stored entries do not verify: %d problems, first %s", len(problems), problems[0].Kind)
+			}
+		})
+	}
+}
+
+func TestSplitCopies(t *testing.T) {
+	tc, err := Create(nil, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.Clock = FixedClock(at("13:00"))
+	e := &Entry{
+		Start:       at("08:00"),
+		End:         at("12:00"),
+		Hash:        "a1",
+		Checkpoints: []time.Time{at("08:30"), at("09:30"), at("11:00")},
+		attrs:       []string{"x=1"},
+	}
+	if _, err := tc.Add(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Split(0, at("09:00"), at("10:00")); err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := tc.Entries[0], tc.Entries[1]
+	if got := summary(tc.Entries); strings.Join(got, "|") != "08:00-09:00 hashed a1 @08:30|10:00-12:00 hashed a1 @11:00" {
+		t.Errorf("entries: %q", got)
+	}
+	second.attrs[0] = "x=2"
+	if first.attrs[0] != "x=1" {
+		t.Errorf("halves share their attributes")
+	}
+	if first.Chain == second.Chain {
+		t.Errorf("halves share their seal")
+	}
+}
+
+func TestUndo(t *testing.T) {
+	store := NewMemoryStore()
+	tc, err := Create(nil, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := OpenJournal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []struct {
+		name string
+		at   string
+		fn   func(*Timecard) error
+	}{
+		{"add", "12:00", added("07:00", "08:00", "a1")},
+		{"start", "12:00", (*Timecard).Start},
+		{"end", "12:30", (*Timecard).End},
+	} {
+		tc.Clock = FixedClock(at(op.at))
+		before := tc.Snapshot()
+		if err := op.fn(tc); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Record(op.name, before, tc.Snapshot()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		undo bool
+		n    int
+		want []string
+	}{
+		{true, 1, []string{"07:00-08:00 hashed a1", "12:00- pending"}},
+		{true, 2, []string{}},
+		{false, 1, []string{"07:00-08:00 hashed a1"}},
+		{false, 5, []string{"07:00-08:00 hashed a1", "12:00-12:30 partial"}},
+	} {
+		if tt.undo {
+			_, err = j.Undo(tc, tt.n, false)
+		} else {
+			_, err = j.Redo(tc, tt.n, false)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		reopened, err := Open(nil, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := summary(reopened.Entries); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("undo=%v n=%d: got %q, want %q", tt.undo, tt.n, got, tt.want)
+		}
+	}
+
+	// The journal keeps only the entry each operation changed, and replays
+	// the undo and redo marks appended to it.
+	j, err = OpenJournal(path.Dir(j.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Ops) != 3 || j.Ops[2].Undone {
+		t.Fatalf("reopened journal: %d operations", len(j.Ops))
+	}
+	if op := j.Ops[2]; op.Index != 1 || len(op.Before) != 1 || len(op.After) != 1 {
+		t.Errorf("end journaled entries %d+%d at %d, want 1+1 at 1", len(op.Before), len(op.After), op.Index)
+	}
+
+	if _, err := j.Undo(tc, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	tc.Clock = FixedClock(at("13:00"))
+	if err := tc.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Undo(tc, 1, false); err == nil {
+		t.Errorf("undo succeeded although the timecard changed behind the journal's back")
+	}
+}
+
+func TestVerifyHead(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPub, oldPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMemoryStore()
+	tc, err := Create(nil, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.Signer = oldPriv
+	for _, hash := range []string{"a1", "b2", "c3"} {
+		if _, err := tc.Add(&Entry{Start: at("09:00"), End: at("10:00"), Hash: hash}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rotating the key keeps the entries signed with the old one valid.
+	tc.Signer, tc.OldKeys = priv, []ed25519.PublicKey{oldPub}
+	if err := added("11:00", "12:00", "d4")(tc); err != nil {
+		t.Fatalf("write after a key rotation: %s", err)
+	}
+	if problems := tc.Verify(pub, oldPub); len(problems) > 0 {
+		t.Errorf("%d problems after a key rotation, first %s", len(problems), problems[0].Kind)
+	}
+	if problems := tc.Verify(pub); len(problems) != 3 || problems[0].Kind != ProblemBadSignature {
+		t.Errorf("entries signed with the old key verify without it: %d problems", len(problems))
+	}
+
+	// Dropping the last entries leaves a valid chain, but not the head.
+	cut := &Timecard{Header: tc.Header, Entries: tc.Entries[:2]}
+	if problems := cut.Verify(); len(problems) != 1 || problems[0].Kind != ProblemHead || problems[0].Index != 2 {
+		t.Errorf("cut timecard: %d problems", len(problems))
+	}
+
+	// Neither can the head be moved back without the key.
+	hdr := *tc.Header
+	hdr.Head = tc.Entries[1].Chain
+	cut.Header = &hdr
+	if problems := cut.Verify(); len(problems) > 0 {
+		t.Errorf("unsigned check of a moved head: %d problems", len(problems))
+	}
+	if problems := cut.Verify(pub, oldPub); len(problems) != 1 || problems[0].Kind != ProblemBadSignature {
+		t.Errorf("signed check of a moved head: %d problems", len(problems))
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
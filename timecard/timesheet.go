@@ -0,0 +1,315 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Period is the calendar span covered by a timesheet.
+type Period int
+
+const (
+	PeriodDay   Period = iota // A single calendar day
+	PeriodWeek  Period = iota // Seven days beginning on the week start
+	PeriodMonth Period = iota // A calendar month
+)
+
+// GroupBy selects what the rows of a timesheet represent.
+type GroupBy int
+
+const (
+	GroupByCommit GroupBy = iota // One row per commit hash
+	GroupByBranch GroupBy = iota // One row per branch
+	GroupByAuthor GroupBy = iota // One row per commit author
+)
+
+const (
+	uncommittedLabel   = "(uncommitted)"
+	unknownAuthorLabel = "(unknown)"
+	shortHashLength    = 7
+)
+
+// ParseWeekday converts a (case insensitive) weekday name such as "monday" or
+// "mon" into a time.Weekday.
+func ParseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(s)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || (len(s) >= 3 && strings.HasPrefix(name, s)) {
+			return d, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("invalid weekday %q", s)
+}
+
+// FormatDuration renders `d` rounded to the minute, e.g. "1h23m" or "45m".
+func FormatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	d = d.Round(time.Minute)
+	h, m := int64(d/time.Hour), int64((d%time.Hour)/time.Minute)
+	if h == 0 {
+		return fmt.Sprintf("%s%dm", sign, m)
+	}
+	return fmt.Sprintf("%s%dh%02dm", sign, h, m)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// TimesheetOptions describes which timesheet to build.
+type TimesheetOptions struct {
+	Period    Period         // Span of the timesheet
+	At        time.Time      // Any instant inside the desired period
+	Location  *time.Location // Timezone of entries which did not record theirs
+	WeekStart time.Weekday   // First day of the week for PeriodWeek
+	GroupBy   GroupBy        // What each row of the timesheet represents
+	Now       time.Time      // End time assumed for pending entries
+	User      string         // Author of uncommitted entries for GroupByAuthor
+}
+
+// TimesheetRow is the time spent on a single commit or branch, broken down
+// per day of the timesheet.
+type TimesheetRow struct {
+	Label   string
+	Days    []time.Duration
+	Total   time.Duration
+	Flagged bool // Some entries in the row break the rules and were clamped
+}
+
+// Timesheet is a calendar aligned grid of days x commits (or branches).
+type Timesheet struct {
+	Days []time.Time // Midnight (in the timesheet's location) of each day
+	Rows []*TimesheetRow
+}
+
+// periodBounds returns the first day and the number of days in the period
+// which contains `opts.At`.
+func periodBounds(opts *TimesheetOptions) (time.Time, int) {
+	at := opts.At.In(opts.Location)
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, opts.Location)
+	switch opts.Period {
+	case PeriodWeek:
+		offset := (int(day.Weekday()) - int(opts.WeekStart) + 7) % 7
+		return day.AddDate(0, 0, -offset), 7
+	case PeriodMonth:
+		first := day.AddDate(0, 0, 1-day.Day())
+		return first, first.AddDate(0, 1, 0).AddDate(0, 0, -1).Day()
+	}
+	return day, 1
+}
+
+// labeler returns a function which labels entries with their commit, branch
+// or author according to `by`.  Uncommitted entries are attributed to the
+// current branch and to `user`.
+func (tc *Timecard) labeler(by GroupBy, user string) (func(*Entry) string, error) {
+	labelFn := func(e *Entry) string {
+		if e.State != cStateHashed || len(e.Hash) == 0 {
+			return uncommittedLabel
+		}
+		if len(e.Hash) > shortHashLength {
+			return e.Hash[:shortHashLength]
+		}
+		return e.Hash
+	}
+	if by == GroupByBranch {
+		if tc.repo == nil {
+			return nil, errors.New("grouping by branch requires a git repository")
+		}
+		branches, err := tc.repo.CommitBranches()
+		if err != nil {
+			return nil, err
+		}
+		current, err := tc.repo.CurrentBranch()
+		if err != nil {
+			return nil, err
+		}
+		labelFn = func(e *Entry) string {
+			if e.State != cStateHashed {
+				return current
+			}
+			if name, ok := branches[e.Hash]; ok {
+				return name
+			}
+			return "(unreachable)"
+		}
+	}
+
+	if by == GroupByAuthor {
+		if tc.repo == nil {
+			return nil, errors.New("grouping by author requires a git repository")
+		}
+		if len(user) == 0 {
+			user = unknownAuthorLabel
+		}
+		authors := map[string]string{}
+		labelFn = func(e *Entry) string {
+			if e.State != cStateHashed {
+				return user
+			}
+			if _, ok := authors[e.Hash]; !ok {
+				authors[e.Hash] = unknownAuthorLabel
+				if c, err := tc.repo.Commit(e.Hash); err == nil {
+					authors[e.Hash] = c.Author
+				}
+			}
+			return authors[e.Hash]
+		}
+	}
+	return labelFn, nil
+}
+
+// Timesheet buckets the timecard's entries into calendar days.  Entries which
+// cross midnight are split across the days they cover, entries which break the
+// timecard's rules only count for their clamped span.  Days are taken in the
+// timezone each entry was recorded in, so that work done while travelling
+// lands on the local day it happened on.
+func (tc *Timecard) Timesheet(opts TimesheetOptions) (*Timesheet, error) {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.Now.IsZero() {
+		opts.Now = tc.Now()
+	}
+	if opts.At.IsZero() {
+		opts.At = opts.Now
+	}
+
+	labelFn, err := tc.labeler(opts.GroupBy, opts.User)
+	if err != nil {
+		return nil, err
+	}
+
+	first, n := periodBounds(&opts)
+	ts := &Timesheet{}
+	for i := 0; i <= n; i++ {
+		ts.Days = append(ts.Days, first.AddDate(0, 0, i))
+	}
+
+	rows := map[string]*TimesheetRow{}
+	for _, e := range tc.Entries {
+		start, end := tc.Rules.Clamp(e, opts.Now)
+		flagged := len(tc.Rules.Check(e, opts.Now)) > 0
+		loc := e.Location(opts.Location)
+		for i := 0; i < n; i++ {
+			from, to := inLocation(ts.Days[i], loc), inLocation(ts.Days[i+1], loc)
+			if start.After(from) {
+				from = start
+			}
+			if end.Before(to) {
+				to = end
+			}
+			if !to.After(from) {
+				continue
+			}
+
+			label := labelFn(e)
+			row, ok := rows[label]
+			if !ok {
+				row = &TimesheetRow{Label: label, Days: make([]time.Duration, n)}
+				rows[label] = row
+				ts.Rows = append(ts.Rows, row)
+			}
+			row.Days[i] += to.Sub(from)
+			row.Total += to.Sub(from)
+			row.Flagged = row.Flagged || flagged
+		}
+	}
+	ts.Days = ts.Days[:n]
+	return ts, nil
+}
+
+// inLocation returns the midnight of the calendar day `day` in `loc`.
+func inLocation(day time.Time, loc *time.Location) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+}
+
+// DayTotals returns the total time tracked on each day of the timesheet.
+func (ts *Timesheet) DayTotals() []time.Duration {
+	totals := make([]time.Duration, len(ts.Days))
+	for _, row := range ts.Rows {
+		for i, d := range row.Days {
+			totals[i] += d
+		}
+	}
+	return totals
+}
+
+// Total returns the total time tracked in the timesheet.
+func (ts *Timesheet) Total() time.Duration {
+	var total time.Duration
+	for _, row := range ts.Rows {
+		total += row.Total
+	}
+	return total
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Tally is the total time tracked by an author on a branch.
+type Tally struct {
+	Author   string
+	Branch   string
+	Duration time.Duration
+}
+
+// Tallies returns the time tracked per author and branch over the timecard's
+// whole history, using the same attribution rules as Timesheet except that
+// entries which were not committed yet are not put on the current branch:
+// tallies must not move from one branch to another as HEAD does.
+func (tc *Timecard) Tallies(now time.Time, user string) ([]*Tally, error) {
+	authorFn, err := tc.labeler(GroupByAuthor, user)
+	if err != nil {
+		return nil, err
+	}
+	branchFn, err := tc.labeler(GroupByBranch, user)
+	if err != nil {
+		return nil, err
+	}
+
+	tallies := map[[2]string]*Tally{}
+	ts := []*Tally{}
+	for _, e := range tc.Entries {
+		start, end := tc.Rules.Clamp(e, now)
+		if !end.After(start) {
+			continue
+		}
+		key := [2]string{authorFn(e), uncommittedLabel}
+		if e.State == cStateHashed {
+			key[1] = branchFn(e)
+		}
+		t, ok := tallies[key]
+		if !ok {
+			t = &Tally{Author: key[0], Branch: key[1]}
+			tallies[key] = t
+			ts = append(ts, t)
+		}
+		t.Duration += end.Sub(start)
+	}
+	return ts, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// CommitTimes returns the time tracked per commit hash, using the same rules
+// as Timesheet.  Entries which were not committed yet are left out.
+func (tc *Timecard) CommitTimes(now time.Time) map[string]time.Duration {
+	times := map[string]time.Duration{}
+	for _, e := range tc.Entries {
+		if e.State != cStateHashed || len(e.Hash) == 0 {
+			continue
+		}
+		start, end := tc.Rules.Clamp(e, now)
+		times[e.Hash] += end.Sub(start)
+	}
+	return times
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,210 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// scissors marks the start of the part of a commit message git cuts away, as
+// written by "git commit --verbose".
+const scissors = "# ------------------------ >8 ------------------------"
+
+// isTrailer returns true if `line` looks like a "Token: value" trailer.
+func isTrailer(line string) bool {
+	i := strings.Index(line, ": ")
+	if i <= 0 {
+		return false
+	}
+	for _, r := range line[:i] {
+		if !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// SetTrailer adds the trailer "`token`: `value`" to the commit message `msg`,
+// or replaces the value of an existing `token` trailer.  The trailer goes at
+// the end of the message proper, before the comments git adds for the editor.
+func SetTrailer(msg, token, value string) string {
+	trailer := token + ": " + value
+	lines := strings.Split(msg, "\n")
+
+	// Split off the comments (and everything after the scissors line) which
+	// git strips from the message.
+	cut := len(lines)
+	for i, line := range lines {
+		if line == scissors {
+			cut = i
+			break
+		}
+	}
+	for cut > 0 && (strings.HasPrefix(lines[cut-1], "#") || len(strings.TrimSpace(lines[cut-1])) == 0) {
+		cut--
+	}
+	body, tail := lines[:cut], lines[cut:]
+
+	for i, line := range body {
+		if isTrailer(line) && strings.EqualFold(line[:strings.Index(line, ":")], token) {
+			body[i] = trailer
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	// Trailers are separated from the rest of the message by a blank line,
+	// unless the message already ends with a block of them.
+	para := len(body)
+	for para > 0 && isTrailer(body[para-1]) {
+		para--
+	}
+	out := append([]string{}, body...)
+	switch {
+	case len(body) == 0:
+		out = append(out, "", "", trailer) // Leave the subject line to be written
+	case para > 1 && para < len(body) && len(strings.TrimSpace(body[para-1])) == 0:
+		out = append(out, trailer)
+	default:
+		out = append(out, "", trailer)
+	}
+	if len(tail) > 0 && len(tail[0]) > 0 {
+		out = append(out, "")
+	}
+	return strings.Join(append(out, tail...), "\n")
+}
+
+// OnlyTrailer returns true if, comments aside, the commit message `msg`
+// consists of nothing but `token` trailers: the message was left empty.
+func OnlyTrailer(msg, token string) bool {
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		if line == scissors {
+			lines = lines[:i]
+			break
+		}
+	}
+	found := false
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#") || len(strings.TrimSpace(line)) == 0:
+		case isTrailer(line) && strings.EqualFold(line[:strings.Index(line, ":")], token):
+			found = true
+		default:
+			return false
+		}
+	}
+	return found
+}
+
+// hasTrailer returns true if the commit message `msg` has a `token` trailer.
+func hasTrailer(msg, token string) bool {
+	for _, line := range strings.Split(msg, "\n") {
+		if line == scissors {
+			break
+		}
+		if isTrailer(line) && strings.EqualFold(line[:strings.Index(line, ":")], token) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrailerHook decides what the prepare-commit-msg hook does with the commit
+// message `msg`, given the `source` and `commit` git passes it: whether to set
+// the `token` trailer, and whether the commit amends HEAD, whose time counts
+// too then.  Merges, squashes and commits reusing another commit's message
+// are left alone, and so are messages which already have the trailer unless
+// HEAD is being amended.
+func TrailerHook(msg, token, source, commit string) (set, amend bool) {
+	switch source {
+	case "merge", "squash":
+		return false, false
+	case "commit":
+		return commit == "HEAD", commit == "HEAD"
+	}
+	return !hasTrailer(msg, token), false
+}
+
+// ParseTrailer returns the duration recorded in the `token` trailer of the
+// commit message `msg`.
+func ParseTrailer(msg, token string) (time.Duration, bool) {
+	var d time.Duration
+	found := false
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimSpace(line)
+		if !isTrailer(line) || !strings.EqualFold(line[:strings.Index(line, ":")], token) {
+			continue
+		}
+		v, err := time.ParseDuration(strings.TrimSpace(line[strings.Index(line, ":")+1:]))
+		if err == nil && v > 0 {
+			d, found = v, true
+		}
+	}
+	return d, found
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// CommitTime returns the time which will be attributed to the next commit,
+// that of the open entry, clamped by the timecard's rules.  If `amended` is
+// set, the time already attributed to that commit is added, so that amending
+// a commit reports its whole time.
+func (tc *Timecard) CommitTime(amended string, now time.Time) time.Duration {
+	var total time.Duration
+	for i, e := range tc.Entries {
+		open := i == len(tc.Entries)-1 && e.State != cStateHashed
+		if open || (len(amended) > 0 && e.Hash == amended) {
+			start, end := tc.Rules.Clamp(e, now)
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
+
+// ImportTrailers adds an entry for every commit reachable in the repository
+// whose message records its time in a `token` trailer, ending when the commit
+// was authored.  Commits which already have entries are skipped.  Returns the
+// number of entries added and of commits skipped.
+func (tc *Timecard) ImportTrailers(token string) (int, int, error) {
+	if tc.repo == nil {
+		return 0, 0, errors.New("importing trailers requires a git repository")
+	}
+	commits, err := tc.repo.Reachable()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tracked := map[string]bool{}
+	for _, e := range tc.Entries {
+		tracked[e.Hash] = true
+	}
+
+	added, skipped := []*Entry{}, 0
+	for _, c := range commits {
+		d, ok := ParseTrailer(c.Message, token)
+		if !ok {
+			continue
+		}
+		if tracked[c.Hash] {
+			skipped++
+			continue
+		}
+		added = append(added, &Entry{
+			Start: c.When.Add(-d),
+			End:   c.When,
+			Hash:  c.Hash,
+			Note:  "imported from " + token + " trailer",
+		})
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].Start.Before(added[j].Start)
+	})
+	return len(added), skipped, tc.insert(added)
+}
+
+////////////////////////////////////////////////////////////////////////////////
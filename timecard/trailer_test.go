@@ -0,0 +1,37 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestTrailerHook(t *testing.T) {
+	const (
+		plain   = "Add the parser\n\n# Please enter the commit message\n"
+		trailed = "Add the parser\n\nTime-Spent: 1h\n"
+	)
+
+	for _, tt := range []struct {
+		name, msg, source, commit string
+		set, amend                bool
+	}{
+		{"new commit", "", "", "", true, false},
+		{"message", plain, "message", "", true, false},
+		{"template", plain, "template", "", true, false},
+		{"already has the trailer", trailed, "message", "", false, false},
+		{"amend", trailed, "commit", "HEAD", true, true},
+		{"reuse another commit's message", plain, "commit", "61f2d0e827833e25ef3688bbc4638df95624af12", false, false},
+		{"merge", plain, "merge", "", false, false},
+		{"squash", plain, "squash", "", false, false},
+	} {
+		set, amend := TrailerHook(tt.msg, "Time-Spent", tt.source, tt.commit)
+		if set != tt.set || amend != tt.amend {
+			t.Errorf("%s: set %v, amend %v, want %v, %v", tt.name, set, amend, tt.set, tt.amend)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,120 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Layouts accepted by ParseTime, times without a date refer to the day of
+// `now`.
+var (
+	dateTimeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04",
+	}
+	clockLayouts = []string{
+		"15:04:05",
+		"15:04",
+	}
+)
+
+// Units accepted in relative times such as "2 hours ago".
+var relativeUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+}
+
+// ParseTime parses a user supplied point in time.  Besides the layouts listed
+// above, it accepts "now", durations before `now` such as "15m ago",
+// "1h30m ago" or "2 hours ago", and days relative to `now` optionally followed
+// by a time of day such as "yesterday 17:30" or "today 9:00".  A bare integer
+// is taken to be seconds since the epoch.
+func ParseTime(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	now = now.In(loc)
+	if t, ok := parseClock(s, now, loc); ok {
+		return t, nil
+	}
+
+	fields := strings.Fields(strings.ToLower(s))
+	switch {
+	case len(fields) == 1 && fields[0] == "now":
+		return now, nil
+	case len(fields) > 1 && fields[len(fields)-1] == "ago":
+		d, err := parseRelative(fields[:len(fields)-1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse time %q: %s", s, err.Error())
+		}
+		return now.Add(-d), nil
+	case len(fields) > 0 && (fields[0] == "today" || fields[0] == "yesterday"):
+		day := now
+		if fields[0] == "yesterday" {
+			day = now.AddDate(0, 0, -1)
+		}
+		if len(fields) == 1 {
+			return day, nil
+		}
+		if t, ok := parseClock(strings.Join(fields[1:], " "), day, loc); ok {
+			return t, nil
+		}
+	}
+
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q", s)
+}
+
+// parseClock parses a time of day on the day of `day`.
+func parseClock(s string, day time.Time, loc *time.Location) (time.Time, bool) {
+	for _, layout := range clockLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return time.Date(day.Year(), day.Month(), day.Day(),
+				t.Hour(), t.Minute(), t.Second(), 0, loc), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseRelative parses the duration of a relative time, either a Go duration
+// ("1h30m") or a number followed by a unit ("2 hours").
+func parseRelative(fields []string) (time.Duration, error) {
+	switch len(fields) {
+	case 1:
+		d, err := time.ParseDuration(fields[0])
+		if err == nil && d < 0 {
+			return 0, fmt.Errorf("negative duration %q", fields[0])
+		}
+		return d, err
+	case 2:
+		n, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", fields[0])
+		}
+		unit, ok := relativeUnits[fields[1]]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit %q", fields[1])
+		}
+		return time.Duration(n) * unit, nil
+	}
+	return 0, fmt.Errorf("expected a duration such as \"15m\" or \"2 hours\"")
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,81 @@
+package timecard
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseTime(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	clock := FixedClock(time.Date(2026, 10, 19, 10, 45, 0, 0, loc))
+	day := func(d, h, m int) time.Time {
+		return time.Date(2026, 10, d, h, m, 0, 0, loc)
+	}
+
+	for _, tt := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"now", day(19, 10, 45)},
+		{" NOW ", day(19, 10, 45)},
+		{"15m ago", day(19, 10, 30)},
+		{"1h30m ago", day(19, 9, 15)},
+		{"2 hours ago", day(19, 8, 45)},
+		{"1 day ago", day(18, 10, 45)},
+		{"45 mins ago", day(19, 10, 0)},
+		{"today 9:00", day(19, 9, 0)},
+		{"today 09:00:30", day(19, 9, 0).Add(30 * time.Second)},
+		{"yesterday 17:30", day(18, 17, 30)},
+		{"Yesterday 17:30", day(18, 17, 30)},
+		{"yesterday", day(18, 10, 45)},
+		{"8:15", day(19, 8, 15)},
+		{"2026-10-01 12:00", day(1, 12, 0)},
+		{"2026-10-01T12:00:00Z", time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)},
+		{"1792400000", time.Unix(1792400000, 0)},
+	} {
+		got, err := ParseTime(tt.in, clock.Now(), loc)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %s", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimeErrors(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	clock := FixedClock(time.Date(2026, 10, 19, 10, 45, 0, 0, loc))
+
+	for _, tt := range []struct {
+		in  string
+		err string // Expected in the error
+	}{
+		{"", "cannot parse time"},
+		{"ago", "cannot parse time"},
+		{"soon", "cannot parse time"},
+		{"-15m ago", "negative duration"},
+		{"15 fortnights ago", "unknown unit"},
+		{"a few hours ago", "expected a duration"},
+		{"two hours ago", "invalid number"},
+		{"15x ago", "cannot parse time"},
+		{"yesterday 25:00", "cannot parse time"},
+		{"tomorrow 9:00", "cannot parse time"},
+		{"today at noon", "cannot parse time"},
+	} {
+		got, err := ParseTime(tt.in, clock.Now(), loc)
+		if err == nil {
+			t.Errorf("ParseTime(%q) = %s, want an error", tt.in, got)
+		} else if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseTime(%q): got error %q, want one containing %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
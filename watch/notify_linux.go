@@ -0,0 +1,139 @@
+//go:build linux
+// +build linux
+
+package watch
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const watchMask = syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotify watches every directory below the root which is not skipped,
+// directories created later on are added as they show up.
+type inotify struct {
+	fd      int
+	f       *os.File // Wraps fd so that Close interrupts pending reads
+	root    string
+	skip    IgnoreFn
+	watches map[int32]string // Watch descriptor to directory, relative to root
+	events  chan Event
+	errors  chan error
+}
+
+func newNotifier(root string, skip IgnoreFn) (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &inotify{
+		fd:      fd,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		root:    root,
+		skip:    skip,
+		watches: map[int32]string{},
+		events:  make(chan Event, 64),
+		errors:  make(chan error, 1),
+	}
+	if err := n.addTree(""); err != nil {
+		n.f.Close()
+		return nil, err
+	}
+	go n.read()
+	return n, nil
+}
+
+func (n *inotify) Events() <-chan Event { return n.events }
+func (n *inotify) Errors() <-chan error { return n.errors }
+func (n *inotify) Close() error         { return n.f.Close() }
+
+// addTree watches the directory `rel` and every directory below it.
+func (n *inotify) addTree(rel string) error {
+	return filepath.Walk(path.Join(n.root, rel), func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed while walking
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		r, _ := filepath.Rel(n.root, fp)
+		r = filepath.ToSlash(r)
+		if r == "." {
+			r = ""
+		}
+		if len(r) > 0 && n.skip(r, true) {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(n.fd, fp, watchMask|syscall.IN_ONLYDIR)
+		if err == syscall.ENOENT {
+			return nil
+		} else if err != nil {
+			return os.NewSyscallError("inotify_add_watch "+fp, err)
+		}
+		n.watches[int32(wd)] = r
+		return nil
+	})
+}
+
+// read decodes the kernel's events until the notifier is closed.
+func (n *inotify) read() {
+	defer close(n.events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		count, err := n.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				n.errors <- err
+			}
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= count; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(raw.Len)]
+			off += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				n.events <- Event{} // Lost track of the changes, still activity
+				continue
+			}
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(n.watches, raw.Wd)
+				continue
+			}
+			dir, ok := n.watches[raw.Wd]
+			if !ok {
+				continue
+			}
+
+			ev := Event{
+				Path:  path.Join(dir, strings.TrimRight(string(name), "\x00")),
+				IsDir: raw.Mask&syscall.IN_ISDIR != 0,
+			}
+			if ev.IsDir && raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if err := n.addTree(ev.Path); err != nil {
+					n.errors <- err
+					return
+				}
+			}
+			n.events <- ev
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
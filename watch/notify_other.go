@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package watch
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func newNotifier(root string, skip IgnoreFn) (notifier, error) {
+	return nil, errors.New("watching the worktree needs inotify, which is only available on Linux")
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,115 @@
+// Package watch detects editing activity in a git worktree, telling when
+// someone starts working on the code and when they stopped.
+package watch
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"path"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Event is a change to a file or directory of the worktree.
+type Event struct {
+	Path  string // Relative to the worktree's root, empty if events were lost
+	IsDir bool
+}
+
+// IgnoreFn reports whether changes to `rel` should be ignored.
+type IgnoreFn func(rel string, isDir bool) bool
+
+// notifier delivers the changes made below a directory, see the platform
+// specific newNotifier.
+type notifier interface {
+	Events() <-chan Event
+	Errors() <-chan error
+	Close() error
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Watcher turns the changes made in the worktree at Root into periods of
+// activity.  The first change after being idle calls Active, no change for
+// Idle calls Inactive with the time of the last change.
+type Watcher struct {
+	Root     string
+	Idle     time.Duration
+	Ignored  func() (IgnoreFn, error)   // Read again whenever a .gitignore changes
+	Active   func(at time.Time) error   // Editing started at `at`
+	Inactive func(last time.Time) error // Editing stopped, `last` was the last change
+}
+
+// Run watches the worktree until `stop` is closed or a callback fails.  If the
+// worktree is active when stopped, Inactive is called one last time.
+func (w *Watcher) Run(stop <-chan struct{}) error {
+	ignored, err := w.Ignored()
+	if err != nil {
+		return err
+	}
+	// The notifier skips ignored directories as they are created, from its
+	// own goroutine.
+	var mu sync.Mutex
+	n, err := newNotifier(w.Root, func(rel string, isDir bool) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return rel == ".git" || ignored(rel, isDir)
+	})
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	idle := time.NewTimer(w.Idle)
+	idle.Stop()
+
+	active, last := false, time.Time{}
+	for {
+		select {
+		case ev, ok := <-n.Events():
+			if !ok {
+				return nil
+			}
+			mu.Lock()
+			skip := len(ev.Path) > 0 && ignored(ev.Path, ev.IsDir)
+			mu.Unlock()
+			if skip {
+				continue
+			}
+			if path.Base(ev.Path) == ".gitignore" {
+				fn, err := w.Ignored()
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				ignored = fn
+				mu.Unlock()
+			}
+
+			last = time.Now()
+			if !active {
+				active = true
+				if err := w.Active(last); err != nil {
+					return err
+				}
+			}
+			idle.Reset(w.Idle)
+		case err := <-n.Errors():
+			return err
+		case <-idle.C:
+			active = false
+			if err := w.Inactive(last); err != nil {
+				return err
+			}
+		case <-stop:
+			if active {
+				return w.Inactive(last)
+			}
+			return nil
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////